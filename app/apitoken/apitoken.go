@@ -0,0 +1,104 @@
+// Package apitoken implements personal access tokens: named, scoped and
+// optionally expiring credentials that scripts and CLIs send as
+// "Authorization: Bearer <token>". Only the signed hash of a token is stored.
+package apitoken
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/utils"
+	"gorm.io/gorm"
+)
+
+// Prefix starts every token so leaked tokens are easy to recognize.
+const Prefix = "scf_"
+
+// Scopes a token can be granted.
+const (
+	ScopeProfileRead  = "profile:read"  // Read the account's profile
+	ScopeProfileWrite = "profile:write" // Update the account's profile
+	ScopeUploads      = "uploads"       // Upload and delete files
+	ScopeAdmin        = "admin"         // Use the account's admin permissions
+)
+
+// Scope describes a scope on the token form.
+type Scope struct {
+	Name        string
+	Description string
+}
+
+// Scopes lists every scope in display order.
+var Scopes = []Scope{
+	{ScopeProfileRead, "Read your profile"},
+	{ScopeProfileWrite, "Update your profile"},
+	{ScopeUploads, "Upload and delete files"},
+	{ScopeAdmin, "Use your admin permissions"},
+}
+
+// ValidScope reports whether name is a known scope.
+func ValidScope(name string) bool {
+	for _, s := range Scopes {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lastUsedInterval limits how often the last-used time is written, so busy
+// tokens do not cause a write per request.
+const lastUsedInterval = time.Minute
+
+// ErrInvalid is returned for unknown, revoked or expired tokens.
+var ErrInvalid = errors.New("apitoken: invalid or expired token")
+
+// Issue creates a token for userID and returns the plaintext, which is shown
+// to the user once and never stored. A nil expiresAt never expires.
+func Issue(db *gorm.DB, userID uint, name string, scopes []string, expiresAt *time.Time) (string, model.PersonalAccessToken, error) {
+	secret, _, err := utils.NewToken()
+	if err != nil {
+		return "", model.PersonalAccessToken{}, err
+	}
+	token := Prefix + secret
+
+	record := model.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: utils.SignToken(token),
+		Hint:      token[:len(Prefix)+4],
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", model.PersonalAccessToken{}, err
+	}
+	return token, record, nil
+}
+
+// Authenticate resolves a plaintext token to its record and owner and
+// records the use.
+func Authenticate(db *gorm.DB, token string) (model.User, model.PersonalAccessToken, error) {
+	var record model.PersonalAccessToken
+	var user model.User
+	if !strings.HasPrefix(token, Prefix) {
+		return user, record, ErrInvalid
+	}
+	if err := db.Where("token_hash = ?", utils.SignToken(token)).First(&record).Error; err != nil {
+		return user, record, ErrInvalid
+	}
+	if record.Expired() {
+		return user, record, ErrInvalid
+	}
+	if err := db.First(&user, record.UserID).Error; err != nil {
+		return user, record, ErrInvalid
+	}
+
+	now := time.Now()
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > lastUsedInterval {
+		db.Model(&record).Update("last_used_at", now)
+	}
+	return user, record, nil
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -13,6 +17,36 @@ func isTruthy(s string) bool {
 	return s == "true" || s == "1" || s == "yes"
 }
 
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// intEnv parses a positive integer from the environment, falling back to def
+// when the variable is unset or invalid.
+func intEnv(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// durationEnv parses a duration such as "24h" from the environment, falling
+// back to def when the variable is unset or invalid.
+func durationEnv(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
 type Config struct {
 	Database struct {
 		DSN string
@@ -20,36 +54,64 @@ type Config struct {
 	Session struct {
 		Secret string
 	}
+	Security struct {
+		EncryptionKey string
+	}
 	Server struct {
-		Port      string
-		AdminPath string
-	}
-	Login struct {
-		PasswordEnabled bool
-		GoogleEnabled   bool
-		GitHubEnabled   bool
-		LinkedInEnabled bool
-		XEnabled        bool
-	}
-	GoogleOAuth struct {
-		ClientID     string
-		ClientSecret string
-		RedirectURL  string
-	}
-	GitHubOAuth struct {
-		ClientID     string
-		ClientSecret string
-		RedirectURL  string
-	}
-	LinkedInOAuth struct {
-		ClientID     string
-		ClientSecret string
-		RedirectURL  string
-	}
-	XOAuth struct {
-		ClientID     string
-		ClientSecret string
-		RedirectURL  string
+		Port           string
+		AdminPath      string
+		BaseURL        string
+		TrustedProxies []string // IPs or CIDRs allowed to set X-Forwarded-For
+	}
+	Login struct { // Defaults for the login settings in app/settings
+		PasswordEnabled  bool
+		PasskeyEnabled   bool
+		MagicLinkEnabled bool
+	}
+	WebAuthn struct {
+		RPID          string
+		RPDisplayName string
+		RPOrigins     []string
+	}
+	Auth struct {
+		RequireEmailVerification bool
+		EmailVerificationTTL     time.Duration
+		PasswordResetTTL         time.Duration
+		RequireAdmin2FA          bool
+		MagicLinkTTL             time.Duration
+		LockoutThreshold         int
+		LockoutDuration          time.Duration
+		IPAttemptLimit           int
+		LoginAttemptRetention    time.Duration
+	}
+	Audit struct {
+		RetentionDays int // Events older than this are pruned; 0 keeps them forever
+	}
+	OIDC struct {
+		IssuerURL string
+		Label     string
+		Scopes    []string
+		Claims    struct {
+			Email     string
+			Username  string
+			Name      string
+			AvatarURL string
+		}
+	}
+	Storage struct {
+		Driver   string // "s3", "r2", "local" or "memory"
+		LocalDir string // Directory the local driver stores files in
+		URLPath  string // Path the local driver serves files from, e.g. /files
+	}
+	S3 struct { // Any S3-compatible service
+		Endpoint        string // Empty for AWS S3
+		Region          string
+		Bucket          string
+		AccessKeyID     string // Empty uses the AWS default credential chain
+		SecretAccessKey string
+		UsePathStyle    bool
+		PublicURL       string // Base URL of generated links; derived from the endpoint when empty
+		ACL             string // Canned ACL for uploaded objects; empty sets none
 	}
 	CloudflareR2 struct {
 		AccountID       string
@@ -57,6 +119,7 @@ type Config struct {
 		SecretAccessKey string
 		Bucket          string
 		Region          string
+		PublicURL       string // r2.dev or custom domain URL of the bucket
 	}
 	Resend struct {
 		APIKey string
@@ -84,6 +147,9 @@ func Load() error {
 		return fmt.Errorf("SESSION_SECRET is required")
 	}
 
+	// Key for encrypting secrets at rest (optional; derived from SESSION_SECRET if unset)
+	C.Security.EncryptionKey = os.Getenv("ENCRYPTION_KEY")
+
 	// Server configuration
 	C.Server.Port = os.Getenv("PORT")
 	if C.Server.Port == "" {
@@ -95,40 +161,82 @@ func Load() error {
 		C.Server.AdminPath = "admin"
 	}
 
+	// Public base URL used to build links in emails
+	C.Server.BaseURL = strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if C.Server.BaseURL == "" {
+		C.Server.BaseURL = "http://localhost:" + C.Server.Port
+	}
+
+	// Reverse proxies trusted to report the client IP (none by default, so
+	// X-Forwarded-For is ignored and the connection's address is used)
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+		}
+		C.Server.TrustedProxies = append(C.Server.TrustedProxies, proxy)
+	}
+
 	// Login method enable flags (optional)
 	if v := os.Getenv("LOGIN_PASSWORD_ENABLED"); v != "" {
 		C.Login.PasswordEnabled = isTruthy(v)
 	} else {
 		C.Login.PasswordEnabled = true
 	}
-	if v := os.Getenv("LOGIN_GOOGLE_ENABLED"); v != "" {
-		C.Login.GoogleEnabled = isTruthy(v)
+	C.Login.PasskeyEnabled = isTruthy(os.Getenv("LOGIN_PASSKEY_ENABLED"))
+	C.Login.MagicLinkEnabled = isTruthy(os.Getenv("LOGIN_MAGIC_LINK_ENABLED"))
+
+	// WebAuthn relying party (defaults derived from APP_BASE_URL)
+	C.WebAuthn.RPDisplayName = os.Getenv("WEBAUTHN_RP_NAME")
+	if C.WebAuthn.RPDisplayName == "" {
+		C.WebAuthn.RPDisplayName = "Scaffold"
+	}
+	C.WebAuthn.RPID = os.Getenv("WEBAUTHN_RP_ID")
+	if C.WebAuthn.RPID == "" {
+		if u, err := url.Parse(C.Server.BaseURL); err == nil {
+			C.WebAuthn.RPID = u.Hostname()
+		}
+	}
+	if v := os.Getenv("WEBAUTHN_RP_ORIGINS"); v != "" {
+		for _, origin := range strings.Split(v, ",") {
+			C.WebAuthn.RPOrigins = append(C.WebAuthn.RPOrigins, strings.TrimRight(strings.TrimSpace(origin), "/"))
+		}
 	} else {
-		C.Login.GoogleEnabled = true
+		C.WebAuthn.RPOrigins = []string{C.Server.BaseURL}
 	}
-	C.Login.GitHubEnabled = isTruthy(os.Getenv("LOGIN_GITHUB_ENABLED"))
-	C.Login.LinkedInEnabled = isTruthy(os.Getenv("LOGIN_LINKEDIN_ENABLED"))
-	C.Login.XEnabled = isTruthy(os.Getenv("LOGIN_X_ENABLED"))
-
-	// Google OAuth configuration (optional)
-	C.GoogleOAuth.ClientID = os.Getenv("GOOGLE_CLIENT_ID")
-	C.GoogleOAuth.ClientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
-	C.GoogleOAuth.RedirectURL = os.Getenv("GOOGLE_REDIRECT_URL")
 
-	// GitHub OAuth configuration (optional)
-	C.GitHubOAuth.ClientID = os.Getenv("GITHUB_CLIENT_ID")
-	C.GitHubOAuth.ClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
-	C.GitHubOAuth.RedirectURL = os.Getenv("GITHUB_REDIRECT_URL")
+	// Account policy (optional)
+	C.Auth.RequireEmailVerification = isTruthy(os.Getenv("REQUIRE_EMAIL_VERIFICATION"))
+	C.Auth.EmailVerificationTTL = durationEnv("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	C.Auth.PasswordResetTTL = durationEnv("PASSWORD_RESET_TTL", time.Hour)
+	C.Auth.RequireAdmin2FA = isTruthy(os.Getenv("REQUIRE_ADMIN_2FA"))
+	C.Auth.MagicLinkTTL = durationEnv("MAGIC_LINK_TTL", 15*time.Minute)
+	C.Auth.LockoutThreshold = intEnv("LOGIN_LOCKOUT_THRESHOLD", 5)
+	C.Auth.LockoutDuration = durationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	C.Auth.IPAttemptLimit = intEnv("LOGIN_IP_ATTEMPT_LIMIT", 20)
+	// Login attempts are needed for at least the lockout window
+	C.Auth.LoginAttemptRetention = durationEnv("LOGIN_ATTEMPT_RETENTION", 30*24*time.Hour)
+	if C.Auth.LoginAttemptRetention < C.Auth.LockoutDuration {
+		C.Auth.LoginAttemptRetention = C.Auth.LockoutDuration
+	}
 
-	// LinkedIn OAuth configuration (optional)
-	C.LinkedInOAuth.ClientID = os.Getenv("LINKEDIN_CLIENT_ID")
-	C.LinkedInOAuth.ClientSecret = os.Getenv("LINKEDIN_CLIENT_SECRET")
-	C.LinkedInOAuth.RedirectURL = os.Getenv("LINKEDIN_REDIRECT_URL")
+	// Audit log retention, applied by the audit-prune command
+	C.Audit.RetentionDays = intEnv("AUDIT_RETENTION_DAYS", 365)
+	if os.Getenv("AUDIT_RETENTION_DAYS") == "0" {
+		C.Audit.RetentionDays = 0
+	}
 
-	// X (Twitter) OAuth configuration (optional)
-	C.XOAuth.ClientID = os.Getenv("X_CLIENT_ID")
-	C.XOAuth.ClientSecret = os.Getenv("X_CLIENT_SECRET")
-	C.XOAuth.RedirectURL = os.Getenv("X_REDIRECT_URL")
+	// Generic OpenID Connect provider (optional; credentials are read as the
+	// "oidc" OAuth provider from OIDC_CLIENT_ID and friends)
+	C.OIDC.IssuerURL = strings.TrimRight(os.Getenv("OIDC_ISSUER_URL"), "/")
+	C.OIDC.Label = envOr("OIDC_LABEL", "Single sign-on")
+	C.OIDC.Scopes = strings.Fields(envOr("OIDC_SCOPES", "openid profile email"))
+	C.OIDC.Claims.Email = envOr("OIDC_CLAIM_EMAIL", "email")
+	C.OIDC.Claims.Username = envOr("OIDC_CLAIM_USERNAME", "preferred_username")
+	C.OIDC.Claims.Name = envOr("OIDC_CLAIM_NAME", "name")
+	C.OIDC.Claims.AvatarURL = envOr("OIDC_CLAIM_AVATAR_URL", "picture")
 
 	// Cloudflare R2 configuration (optional)
 	C.CloudflareR2.AccountID = os.Getenv("CLOUDFLARE_ACCOUNT_ID")
@@ -139,6 +247,32 @@ func Load() error {
 	if C.CloudflareR2.Region == "" {
 		C.CloudflareR2.Region = "auto"
 	}
+	C.CloudflareR2.PublicURL = os.Getenv("CLOUDFLARE_R2_PUBLIC_URL")
+
+	// Generic S3-compatible storage (optional)
+	C.S3.Endpoint = os.Getenv("S3_ENDPOINT")
+	C.S3.Region = envOr("S3_REGION", "us-east-1")
+	C.S3.Bucket = os.Getenv("S3_BUCKET")
+	C.S3.AccessKeyID = os.Getenv("S3_ACCESS_KEY_ID")
+	C.S3.SecretAccessKey = os.Getenv("S3_SECRET_ACCESS_KEY")
+	C.S3.UsePathStyle = isTruthy(os.Getenv("S3_USE_PATH_STYLE"))
+	C.S3.PublicURL = os.Getenv("S3_PUBLIC_URL")
+	C.S3.ACL = os.Getenv("S3_ACL")
+
+	// File storage; defaults to S3 or R2 when configured, else local disk
+	C.Storage.Driver = strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+	if C.Storage.Driver == "" {
+		switch {
+		case C.S3.Bucket != "":
+			C.Storage.Driver = "s3"
+		case C.R2Configured():
+			C.Storage.Driver = "r2"
+		default:
+			C.Storage.Driver = "local"
+		}
+	}
+	C.Storage.LocalDir = envOr("STORAGE_LOCAL_DIR", "uploads")
+	C.Storage.URLPath = "/" + strings.Trim(envOr("STORAGE_URL_PATH", "/files"), "/")
 
 	// Resend email configuration (optional)
 	C.Resend.APIKey = os.Getenv("RESEND_API_KEY")
@@ -147,22 +281,54 @@ func Load() error {
 	return nil
 }
 
-// OAuthGoogleEnabled returns true if Google login is enabled and configured.
-func (c *Config) OAuthGoogleEnabled() bool {
-	return c.Login.GoogleEnabled && c.GoogleOAuth.ClientID != "" && c.GoogleOAuth.RedirectURL != ""
+// OAuthProviderConfig holds the settings for one OAuth login provider.
+type OAuthProviderConfig struct {
+	Enabled      bool
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Configured returns true if the provider is enabled and has a client ID.
+func (p OAuthProviderConfig) Configured() bool {
+	return p.Enabled && p.ClientID != ""
+}
+
+// OAuthProvider returns the settings for the named OAuth provider, read from
+// LOGIN_<NAME>_ENABLED, <NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET and
+// <NAME>_REDIRECT_URL. enabledByDefault applies when LOGIN_<NAME>_ENABLED is
+// unset, and the redirect URL defaults to /auth/<name>/callback on the public
+// base URL. Load must have run first so .env values are in the environment.
+func (c *Config) OAuthProvider(name string, enabledByDefault bool) OAuthProviderConfig {
+	prefix := strings.ToUpper(name)
+	p := OAuthProviderConfig{
+		Enabled:      enabledByDefault,
+		ClientID:     os.Getenv(prefix + "_CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "_REDIRECT_URL"),
+	}
+	if v := os.Getenv("LOGIN_" + prefix + "_ENABLED"); v != "" {
+		p.Enabled = isTruthy(v)
+	}
+	if p.RedirectURL == "" {
+		p.RedirectURL = c.URL("/auth/" + name + "/callback")
+	}
+	return p
 }
 
-// OAuthGitHubEnabled returns true if GitHub login is enabled and configured.
-func (c *Config) OAuthGitHubEnabled() bool {
-	return c.Login.GitHubEnabled && c.GitHubOAuth.ClientID != "" && c.GitHubOAuth.RedirectURL != ""
+// WebAuthnConfigured returns true if the WebAuthn relying party needed for
+// passkeys is configured. Whether passkey login is on is a runtime setting.
+func (c *Config) WebAuthnConfigured() bool {
+	return c.WebAuthn.RPID != "" && len(c.WebAuthn.RPOrigins) > 0
 }
 
-// OAuthLinkedInEnabled returns true if LinkedIn login is enabled and configured.
-func (c *Config) OAuthLinkedInEnabled() bool {
-	return c.Login.LinkedInEnabled && c.LinkedInOAuth.ClientID != "" && c.LinkedInOAuth.RedirectURL != ""
+// R2Configured returns true if every Cloudflare R2 credential is set.
+func (c *Config) R2Configured() bool {
+	return c.CloudflareR2.AccountID != "" && c.CloudflareR2.AccessKeyID != "" &&
+		c.CloudflareR2.SecretAccessKey != "" && c.CloudflareR2.Bucket != ""
 }
 
-// OAuthXEnabled returns true if X (Twitter) login is enabled and configured.
-func (c *Config) OAuthXEnabled() bool {
-	return c.Login.XEnabled && c.XOAuth.ClientID != "" && c.XOAuth.RedirectURL != ""
+// URL returns an absolute URL for path on the public base URL.
+func (c *Config) URL(path string) string {
+	return c.Server.BaseURL + path
 }
@@ -0,0 +1,37 @@
+// Package dbtest opens throwaway databases for tests.
+package dbtest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var counter atomic.Int64
+
+// Open returns an empty in-memory SQLite database with tables for models. It
+// is closed when the test ends. SQLite stands in for Postgres, so code under
+// test must stick to SQL both understand.
+func Open(t testing.TB, models ...interface{}) *gorm.DB {
+	t.Helper()
+	// A named shared-cache database keeps every pooled connection on the
+	// same data
+	dsn := fmt.Sprintf("file:dbtest%d?mode=memory&cache=shared&_foreign_keys=1", counter.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -2,9 +2,15 @@ package admin
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/dariubs/scaffold/app/audit"
 	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/dariubs/scaffold/app/settings"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func AdminHome() gin.HandlerFunc {
@@ -28,9 +34,103 @@ func AdminHome() gin.HandlerFunc {
 			return
 		}
 
-		c.HTML(http.StatusOK, "admin.home.html", gin.H{
-			"Title": "Admin Dashboard",
-			"User":  adminUser,
+		data := dashboardData(c)
+		data["User"] = adminUser
+		c.HTML(http.StatusOK, "admin.home.html", data)
+	}
+}
+
+// pageData builds the template data every admin page needs: the signed-in
+// admin, the admin base path, their permissions and the CSRF token.
+func pageData(c *gin.Context, title string) gin.H {
+	return gin.H{
+		"Title":       title,
+		"User":        c.MustGet("user"),
+		"AdminPath":   settings.String(settings.AdminPath),
+		"Permissions": c.MustGet("permissions"),
+		"CSRFToken":   c.GetString("csrf_token"),
+	}
+}
+
+// dashboardData builds the template data shared by dashboard form handlers.
+func dashboardData(c *gin.Context) gin.H {
+	return pageData(c, "Admin Dashboard")
+}
+
+// RevokeUserSessions signs a user out everywhere by deleting all of their
+// sessions.
+func RevokeUserSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data := dashboardData(c)
+
+		var target model.User
+		email := strings.TrimSpace(c.PostForm("email"))
+		if err := db.Where("email = ?", email).First(&target).Error; err != nil {
+			data["Error"] = "No user found with that email"
+			c.HTML(http.StatusOK, "admin.home.html", data)
+			return
+		}
+		if !rbac.CanManage(db, c.MustGet("user").(model.User).ID, target.ID) {
+			data["Error"] = errCannotManage
+			c.HTML(http.StatusOK, "admin.home.html", data)
+			return
+		}
+
+		if err := sessionstore.RevokeUser(db, target.ID); err != nil {
+			data["Error"] = "Failed to revoke sessions"
+			c.HTML(http.StatusOK, "admin.home.html", data)
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionSessionsRevoked,
+			ActorID:  audit.Actor(c),
+			TargetID: audit.ID(target.ID),
+			Metadata: map[string]interface{}{"via": "admin"},
 		})
+
+		data["Message"] = "All sessions for " + target.Email + " have been revoked."
+		c.HTML(http.StatusOK, "admin.home.html", data)
 	}
 }
+
+// UnlockUser clears a temporary login lockout for the user with the posted
+// email.
+func UnlockUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		data := dashboardData(c)
+
+		var target model.User
+		email := strings.TrimSpace(c.PostForm("email"))
+		if err := db.Where("email = ?", email).First(&target).Error; err != nil {
+			data["Error"] = "No user found with that email"
+			c.HTML(http.StatusOK, "admin.home.html", data)
+			return
+		}
+		if !rbac.CanManage(db, adminUser.ID, target.ID) {
+			data["Error"] = errCannotManage
+			c.HTML(http.StatusOK, "admin.home.html", data)
+			return
+		}
+
+		err := db.Model(&target).Updates(map[string]interface{}{
+			"failed_logins":        0,
+			"last_failed_login_at": nil,
+			"locked_until":         nil,
+		}).Error
+		if err != nil {
+			data["Error"] = "Failed to unlock account"
+			c.HTML(http.StatusOK, "admin.home.html", data)
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionAccountUnlocked,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+			Metadata: map[string]interface{}{"via": "admin"},
+		})
+		data["Message"] = target.Email + " has been unlocked."
+		c.HTML(http.StatusOK, "admin.home.html", data)
+	}
+}
\ No newline at end of file
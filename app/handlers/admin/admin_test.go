@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/database/dbtest"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// testDB returns a database with the default roles seeded.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	config.C = &config.Config{} // Setting defaults read the config
+	db := dbtest.Open(t, &model.User{}, &model.Permission{}, &model.Role{}, &model.UserRole{},
+		&model.Session{}, &model.AuditEvent{})
+	if err := rbac.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createUser creates a user holding roles.
+func createUser(t *testing.T, db *gorm.DB, name string, roles ...string) model.User {
+	t.Helper()
+	user := model.User{Username: name, Email: name + "@example.com"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range roles {
+		if err := rbac.Assign(db, user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// post runs handler for a form POST signed in as actor and returns the
+// rendered error or message.
+func post(t *testing.T, db *gorm.DB, actor model.User, handler gin.HandlerFunc, path string, form url.Values) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	perms, err := rbac.Permissions(db, actor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.New("")
+	for _, name := range []string{"admin.home.html", "admin.user.html", "error.html"} {
+		template.Must(tmpl.New(name).Parse("{{.Error}}{{.Message}}"))
+	}
+	r := gin.New()
+	r.SetHTMLTemplate(tmpl)
+	r.POST("/:id/*action", func(c *gin.Context) {
+		c.Set("user", actor)
+		c.Set("permissions", perms)
+	}, handler)
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	db := testDB(t)
+	admin := createUser(t, db, "admin", rbac.RoleAdmin)
+	support := createUser(t, db, "support", "support")
+	moderator := createUser(t, db, "moderator", "moderator")
+
+	sessions := func() int64 {
+		var n int64
+		db.Model(&model.Session{}).Where("user_id = ?", moderator.ID).Count(&n)
+		return n
+	}
+	if err := db.Create(&model.Session{ID: "s1", UserID: &moderator.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"email": {moderator.Email}}
+
+	if got := post(t, db, support, RevokeUserSessions(db), "/0/revoke", form); got != errCannotManage {
+		t.Errorf("support revoking a moderator: %q, want %q", got, errCannotManage)
+	}
+	if sessions() != 1 {
+		t.Error("support revoked a moderator's sessions")
+	}
+
+	post(t, db, admin, RevokeUserSessions(db), "/0/revoke", form)
+	if sessions() != 0 {
+		t.Error("admin could not revoke a moderator's sessions")
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	db := testDB(t)
+	admin := createUser(t, db, "admin", rbac.RoleAdmin)
+	moderator := createUser(t, db, "moderator", "moderator")
+	support := createUser(t, db, "support", "support")
+
+	locked := func() bool {
+		var user model.User
+		db.First(&user, support.ID)
+		return user.FailedLogins > 0
+	}
+	db.Model(&support).Update("failed_logins", 5)
+	form := url.Values{"email": {support.Email}}
+
+	if got := post(t, db, moderator, UnlockUser(db), "/0/unlock", form); got != errCannotManage {
+		t.Errorf("moderator unlocking support: %q, want %q", got, errCannotManage)
+	}
+	if !locked() {
+		t.Error("moderator unlocked a user with admin panel access")
+	}
+
+	post(t, db, admin, UnlockUser(db), "/0/unlock", form)
+	if locked() {
+		t.Error("admin could not unlock the user")
+	}
+}
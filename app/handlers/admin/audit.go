@@ -0,0 +1,232 @@
+package admin
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// auditEventsPerPage is the page size of the audit log.
+const auditEventsPerPage = 50
+
+// auditDateLayout is the format of the from and to filter dates.
+const auditDateLayout = "2006-01-02"
+
+// auditForm holds the audit log's query parameters as submitted, so the
+// filter form can be redisplayed.
+type auditForm struct {
+	Action string
+	Actor  string // Email or user ID
+	Target string // Email or user ID
+	From   string // YYYY-MM-DD, inclusive
+	To     string // YYYY-MM-DD, inclusive
+	Page   int
+}
+
+// values returns the query string for f without the page number.
+func (f auditForm) values() url.Values {
+	v := url.Values{}
+	for key, value := range map[string]string{
+		"action": f.Action, "actor": f.Actor, "target": f.Target, "from": f.From, "to": f.To,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	return v
+}
+
+// url returns the audit log URL for f showing page.
+func (f auditForm) url(page int) string {
+	v := f.values()
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	path := "/" + settings.String(settings.AdminPath) + "/audit"
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+// auditEvent is an audit event with the emails of the users it refers to.
+type auditEvent struct {
+	model.AuditEvent
+	ActorEmail  string
+	TargetEmail string
+}
+
+// parseAuditFilter reads the audit log's query parameters. It returns an
+// error message when a user or date cannot be resolved.
+func parseAuditFilter(c *gin.Context, db *gorm.DB) (auditForm, audit.Filter, string) {
+	form := auditForm{
+		Action: c.Query("action"),
+		Actor:  strings.TrimSpace(c.Query("actor")),
+		Target: strings.TrimSpace(c.Query("target")),
+		From:   c.Query("from"),
+		To:     c.Query("to"),
+	}
+	form.Page, _ = strconv.Atoi(c.Query("page"))
+	if form.Page < 1 {
+		form.Page = 1
+	}
+
+	filter := audit.Filter{Action: form.Action}
+	var ok bool
+	if form.Actor != "" {
+		if filter.ActorID, ok = resolveUser(db, form.Actor); !ok {
+			return form, filter, "No user found for actor " + form.Actor
+		}
+	}
+	if form.Target != "" {
+		if filter.TargetID, ok = resolveUser(db, form.Target); !ok {
+			return form, filter, "No user found for target " + form.Target
+		}
+	}
+	if form.From != "" {
+		t, err := time.ParseInLocation(auditDateLayout, form.From, time.Local)
+		if err != nil {
+			return form, filter, "Invalid from date"
+		}
+		filter.Since = t
+	}
+	if form.To != "" {
+		t, err := time.ParseInLocation(auditDateLayout, form.To, time.Local)
+		if err != nil {
+			return form, filter, "Invalid to date"
+		}
+		filter.Until = t.AddDate(0, 0, 1)
+	}
+	return form, filter, ""
+}
+
+// resolveUser returns the ID of the user, deleted or not, with the given
+// email or numeric ID.
+func resolveUser(db *gorm.DB, ref string) (uint, bool) {
+	var user model.User
+	query := db.Unscoped().Select("id")
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("email = ?", ref)
+	}
+	if err := query.First(&user).Error; err != nil {
+		return 0, false
+	}
+	return user.ID, true
+}
+
+// auditQuery selects the events filter matches, newest first, joined with
+// the emails of their actor and target.
+func auditQuery(db *gorm.DB, filter audit.Filter) *gorm.DB {
+	return filter.Apply(db.Model(&model.AuditEvent{})).
+		Select("audit_events.*, actors.email AS actor_email, targets.email AS target_email").
+		Joins("LEFT JOIN users actors ON actors.id = audit_events.actor_id").
+		Joins("LEFT JOIN users targets ON targets.id = audit_events.target_id").
+		Order("audit_events.id DESC")
+}
+
+// AuditLog lists audit events a page at a time, filtered by action, actor,
+// target and date range.
+func AuditLog(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, filter, errMsg := parseAuditFilter(c, db)
+
+		data := pageData(c, "Audit log")
+		data["Filter"] = form
+		data["Actions"] = audit.Actions
+		data["ExportURL"] = "/" + settings.String(settings.AdminPath) + "/audit/export?" + form.values().Encode()
+		data["RetentionDays"] = config.C.Audit.RetentionDays
+		if errMsg != "" {
+			data["Error"] = errMsg
+			data["Events"] = []auditEvent{}
+			c.HTML(http.StatusOK, "admin.audit.html", data)
+			return
+		}
+
+		var total int64
+		filter.Apply(db.Model(&model.AuditEvent{})).Count(&total)
+		var events []auditEvent
+		auditQuery(db, filter).Offset((form.Page - 1) * auditEventsPerPage).Limit(auditEventsPerPage).Scan(&events)
+
+		data["Events"] = events
+		data["Total"] = total
+		if form.Page > 1 {
+			data["PrevURL"] = form.url(form.Page - 1)
+		}
+		if int64(form.Page*auditEventsPerPage) < total {
+			data["NextURL"] = form.url(form.Page + 1)
+		}
+		c.HTML(http.StatusOK, "admin.audit.html", data)
+	}
+}
+
+// ExportAuditLog streams every event matching the audit log filters as CSV.
+func ExportAuditLog(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, filter, errMsg := parseAuditFilter(c, db)
+		if errMsg != "" {
+			c.String(http.StatusBadRequest, errMsg)
+			return
+		}
+
+		rows, err := auditQuery(db, filter).Rows()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to export audit log")
+			return
+		}
+		defer rows.Close()
+
+		filename := "audit-" + time.Now().Format("20060102-150405") + ".csv"
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(http.StatusOK)
+
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "created_at", "action", "actor_id", "actor_email", "target_id", "target_email", "ip", "user_agent", "metadata"})
+		for rows.Next() {
+			var e auditEvent
+			if err := db.ScanRows(rows, &e); err != nil {
+				break
+			}
+			w.Write([]string{
+				strconv.FormatUint(uint64(e.ID), 10),
+				e.CreatedAt.UTC().Format(time.RFC3339),
+				e.Action,
+				optionalID(e.ActorID),
+				csvCell(e.ActorEmail),
+				optionalID(e.TargetID),
+				csvCell(e.TargetEmail),
+				csvCell(e.IP),
+				csvCell(e.UserAgent),
+				csvCell(e.Metadata),
+			})
+		}
+		w.Flush()
+	}
+}
+
+// csvCell keeps spreadsheet applications from evaluating s as a formula.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// roleMember is a user holding a role, as listed on the roles page.
+type roleMember struct {
+	RoleID   uint
+	UserID   uint
+	Username string
+	Email    string
+}
+
+// rolesData builds the template data for the roles page: every role with its
+// permissions and members.
+func rolesData(c *gin.Context, db *gorm.DB) gin.H {
+	var roles []model.Role
+	db.Preload("Permissions").Order("name").Find(&roles)
+
+	var members []roleMember
+	db.Table("user_roles").
+		Select("user_roles.role_id, users.id AS user_id, users.username, users.email").
+		Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL").
+		Order("users.email").Scan(&members)
+	byRole := map[uint][]roleMember{}
+	for _, m := range members {
+		byRole[m.RoleID] = append(byRole[m.RoleID], m)
+	}
+
+	data := pageData(c, "Roles")
+	data["Roles"] = roles
+	data["Members"] = byRole
+	return data
+}
+
+// Roles lists roles, their permissions and the users assigned to them.
+func Roles(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, "admin.roles.html", rolesData(c, db))
+	}
+}
+
+// AssignRole gives the user with the posted email the posted role.
+func AssignRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changeRole(c, db, true)
+	}
+}
+
+// UnassignRole removes the posted role from the user with the posted email.
+func UnassignRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changeRole(c, db, false)
+	}
+}
+
+func changeRole(c *gin.Context, db *gorm.DB, assign bool) {
+	adminUser := c.MustGet("user").(model.User)
+	role := c.PostForm("role")
+
+	var target model.User
+	email := strings.TrimSpace(c.PostForm("email"))
+	if err := db.Where("email = ?", email).First(&target).Error; err != nil {
+		data := rolesData(c, db)
+		data["Error"] = "No user found with that email"
+		c.HTML(http.StatusOK, "admin.roles.html", data)
+		return
+	}
+
+	var err error
+	action := audit.ActionRoleAssigned
+	if assign {
+		err = rbac.Assign(db, target.ID, role)
+	} else {
+		action = audit.ActionRoleUnassigned
+		err = rbac.Unassign(db, target.ID, role)
+	}
+	if err != nil {
+		data := rolesData(c, db)
+		switch {
+		case errors.Is(err, rbac.ErrLastAdmin):
+			data["Error"] = "At least one user must keep the " + rbac.RoleAdmin + " role"
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			data["Error"] = "Unknown role"
+		default:
+			data["Error"] = "Failed to update roles"
+		}
+		c.HTML(http.StatusOK, "admin.roles.html", data)
+		return
+	}
+
+	audit.Record(db, c, audit.Event{
+		Action:   action,
+		ActorID:  audit.ID(adminUser.ID),
+		TargetID: audit.ID(target.ID),
+		Metadata: map[string]interface{}{"role": role},
+	})
+
+	data := rolesData(c, db)
+	if assign {
+		data["Message"] = target.Email + " now has the " + role + " role."
+	} else {
+		data["Message"] = target.Email + " no longer has the " + role + " role."
+	}
+	c.HTML(http.StatusOK, "admin.roles.html", data)
+}
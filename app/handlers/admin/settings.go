@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// settingRow is a setting as shown on the settings page.
+type settingRow struct {
+	settings.Definition
+	Value        string
+	DefaultValue string
+	Saved        bool // False while the setting uses its default
+}
+
+// renderSettings shows every registered setting with its current value.
+func renderSettings(c *gin.Context, message, errMsg string) {
+	var rows []settingRow
+	for _, def := range settings.Definitions() {
+		row := settingRow{Definition: def, Value: settings.Value(def.Key)}
+		if def.Default != nil {
+			row.DefaultValue = def.Default()
+		}
+		_, row.Saved = settings.Saved(def.Key)
+		rows = append(rows, row)
+	}
+
+	data := pageData(c, "Settings")
+	data["Settings"] = rows
+	if message != "" {
+		data["Message"] = message
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	c.HTML(http.StatusOK, "admin.settings.html", data)
+}
+
+// Settings lists the runtime settings.
+func Settings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		renderSettings(c, "", "")
+	}
+}
+
+// UpdateSetting saves the posted value of a setting. Unchecked boolean
+// settings post no value and are saved as false.
+func UpdateSetting(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		key := c.PostForm("key")
+		value := c.PostForm("value")
+		if def, ok := settings.Lookup(key); ok && def.Type == settings.TypeBool && value == "" {
+			value = "false"
+		}
+
+		value, err := settings.Normalize(key, value)
+		if err != nil {
+			if errors.Is(err, settings.ErrUnknown) {
+				renderSettings(c, "", "Unknown setting")
+				return
+			}
+			renderSettings(c, "", err.Error())
+			return
+		}
+		old := settings.Value(key)
+		if err := settings.Set(db, key, value, adminUser.ID); err != nil {
+			renderSettings(c, "", "Failed to save setting")
+			return
+		}
+		recordSettingChange(db, c, key, old, value)
+
+		message := "Setting saved."
+		if key == string(settings.AdminPath) && value != old {
+			message = "Setting saved. The admin panel is now at /" + value + "/."
+		}
+		renderSettings(c, message, "")
+	}
+}
+
+// ResetSetting deletes the saved value of a setting so it uses its default
+// again.
+func ResetSetting(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.PostForm("key")
+		old := settings.Value(key)
+		if err := settings.Reset(db, key); err != nil {
+			if errors.Is(err, settings.ErrUnknown) {
+				renderSettings(c, "", "Unknown setting")
+				return
+			}
+			renderSettings(c, "", "Failed to reset setting")
+			return
+		}
+		recordSettingChange(db, c, key, old, settings.Value(key))
+		renderSettings(c, "Setting reset to its default.", "")
+	}
+}
+
+func recordSettingChange(db *gorm.DB, c *gin.Context, key, old, value string) {
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionSettingChanged,
+		ActorID:  audit.Actor(c),
+		Metadata: map[string]interface{}{"key": key, "old": old, "new": value},
+	})
+}
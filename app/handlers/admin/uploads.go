@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/dariubs/scaffold/app/storage"
+	"github.com/dariubs/scaffold/app/uploads"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// uploadsPerPage is the page size of the uploads list.
+const uploadsPerPage = 50
+
+// uploadFilter holds the uploads list's query parameters.
+type uploadFilter struct {
+	Owner  string // Email or user ID
+	Folder string
+	Page   int
+}
+
+func parseUploadFilter(c *gin.Context) uploadFilter {
+	f := uploadFilter{
+		Owner:  strings.TrimSpace(c.Query("owner")),
+		Folder: strings.TrimSpace(c.Query("folder")),
+	}
+	f.Page, _ = strconv.Atoi(c.Query("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	return f
+}
+
+// values returns the query string for f, including the page number.
+func (f uploadFilter) values(page int) url.Values {
+	v := url.Values{}
+	if f.Owner != "" {
+		v.Set("owner", f.Owner)
+	}
+	if f.Folder != "" {
+		v.Set("folder", f.Folder)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return v
+}
+
+// url returns the list URL for f showing page.
+func (f uploadFilter) url(page int) string {
+	path := "/" + settings.String(settings.AdminPath) + "/uploads"
+	if v := f.values(page); len(v) > 0 {
+		return path + "?" + v.Encode()
+	}
+	return path
+}
+
+// uploadRow is an upload as shown in the list.
+type uploadRow struct {
+	model.Upload
+	OwnerEmail string
+	URL        string
+	SizeLabel  string
+}
+
+// formatSize returns n bytes in the largest whole unit, e.g. "1.4 MB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// renderUploads shows a page of uploads across all users, filtered by owner
+// and folder. Delete buttons are shown when store is available.
+func renderUploads(c *gin.Context, db *gorm.DB, store storage.Storage, message, errMsg string) {
+	f := parseUploadFilter(c)
+	data := pageData(c, "Uploads")
+	data["Filter"] = f
+	data["Query"] = f.values(f.Page).Encode()
+	data["CanDelete"] = store != nil
+	if message != "" {
+		data["Message"] = message
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+
+	query := db.Model(&model.Upload{})
+	if f.Owner != "" {
+		ownerID, ok := resolveUser(db, f.Owner)
+		if !ok {
+			data["Error"] = "No user found for owner " + f.Owner
+			data["Uploads"] = []uploadRow{}
+			c.HTML(http.StatusOK, "admin.uploads.html", data)
+			return
+		}
+		query = query.Where("uploads.user_id = ?", ownerID)
+	}
+	if f.Folder != "" {
+		query = query.Where("uploads.folder = ?", f.Folder)
+	}
+
+	var total int64
+	query.Count(&total)
+	var rows []uploadRow
+	query.Select("uploads.*, users.email AS owner_email").
+		Joins("LEFT JOIN users ON users.id = uploads.user_id").
+		Order("uploads.id DESC").
+		Offset((f.Page - 1) * uploadsPerPage).Limit(uploadsPerPage).
+		Scan(&rows)
+	for i := range rows {
+		if store != nil {
+			rows[i].URL = store.URL(rows[i].Key)
+		}
+		rows[i].SizeLabel = formatSize(rows[i].Size)
+	}
+
+	var folders []string
+	db.Model(&model.Upload{}).Distinct("folder").Order("folder").Pluck("folder", &folders)
+
+	data["Uploads"] = rows
+	data["Folders"] = folders
+	data["Total"] = total
+	if f.Page > 1 {
+		data["PrevURL"] = f.url(f.Page - 1)
+	}
+	if int64(f.Page*uploadsPerPage) < total {
+		data["NextURL"] = f.url(f.Page + 1)
+	}
+	c.HTML(http.StatusOK, "admin.uploads.html", data)
+}
+
+// Uploads lists stored files across all users.
+func Uploads(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		renderUploads(c, db, store, "", "")
+	}
+}
+
+// DeleteUpload deletes any user's file. The list's filters are kept in the
+// query string so the same page is shown afterwards.
+func DeleteUpload(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var upload model.Upload
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil || db.First(&upload, id).Error != nil {
+			renderUploads(c, db, store, "", "File not found")
+			return
+		}
+		if err := uploads.Delete(c.Request.Context(), db, store, upload); err != nil {
+			renderUploads(c, db, store, "", "Failed to delete file")
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionFileDeleted,
+			ActorID:  audit.Actor(c),
+			TargetID: audit.ID(upload.UserID),
+			Metadata: map[string]interface{}{"key": upload.Key, "upload_id": upload.ID, "via": "admin"},
+		})
+		renderUploads(c, db, store, upload.Key+" has been deleted.", "")
+	}
+}
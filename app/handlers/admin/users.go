@@ -0,0 +1,619 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/handlers/index"
+	"github.com/dariubs/scaffold/app/impersonation"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// usersPerPage is the page size of the user list.
+const usersPerPage = 25
+
+// maxSuspensionDays and maxSuspensionReason bound the suspension form.
+const (
+	maxSuspensionDays   = 3650
+	maxSuspensionReason = 500
+)
+
+// suspendedNow matches users whose suspension is in effect at the given time.
+const suspendedNow = "suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > ?)"
+
+// userFilter holds the user list's query parameters.
+type userFilter struct {
+	Query       string // Matches username, email or name
+	LoginMethod string // Exact sign-up method; empty for any
+	Admin       string // "yes", "no" or empty for any
+	Status      string // "active" (default), "suspended", "deleted" or "all"
+	Page        int
+}
+
+func parseUserFilter(c *gin.Context) userFilter {
+	f := userFilter{
+		Query:       strings.TrimSpace(c.Query("q")),
+		LoginMethod: c.Query("login_method"),
+		Admin:       c.Query("admin"),
+		Status:      c.Query("status"),
+	}
+	if f.Admin != "yes" && f.Admin != "no" {
+		f.Admin = ""
+	}
+	if f.Status != "suspended" && f.Status != "deleted" && f.Status != "all" {
+		f.Status = "active"
+	}
+	f.Page, _ = strconv.Atoi(c.Query("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	return f
+}
+
+// url returns the list URL for f showing page.
+func (f userFilter) url(page int) string {
+	v := url.Values{}
+	if f.Query != "" {
+		v.Set("q", f.Query)
+	}
+	if f.LoginMethod != "" {
+		v.Set("login_method", f.LoginMethod)
+	}
+	if f.Admin != "" {
+		v.Set("admin", f.Admin)
+	}
+	if f.Status != "active" {
+		v.Set("status", f.Status)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	path := "/" + settings.String(settings.AdminPath) + "/users"
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+// adminRoleExists matches users holding the admin role.
+const adminRoleExists = "EXISTS (SELECT 1 FROM user_roles JOIN roles ON roles.id = user_roles.role_id " +
+	"WHERE user_roles.user_id = users.id AND roles.name = ?)"
+
+// Users lists users a page at a time, filtered by search text, sign-up
+// method, admin role and status.
+func Users(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := parseUserFilter(c)
+
+		query := db.Model(&model.User{})
+		switch f.Status {
+		case "suspended":
+			query = query.Where(suspendedNow, time.Now())
+		case "deleted":
+			query = query.Unscoped().Where("deleted_at IS NOT NULL")
+		case "all":
+			query = query.Unscoped()
+		}
+		if f.Query != "" {
+			like := "%" + strings.ToLower(f.Query) + "%"
+			query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ? OR LOWER(name) LIKE ?", like, like, like)
+		}
+		if f.LoginMethod != "" {
+			query = query.Where("login_method = ?", f.LoginMethod)
+		}
+		switch f.Admin {
+		case "yes":
+			query = query.Where(adminRoleExists, rbac.RoleAdmin)
+		case "no":
+			query = query.Where("NOT "+adminRoleExists, rbac.RoleAdmin)
+		}
+
+		var total int64
+		query.Count(&total)
+		var users []model.User
+		query.Order("id DESC").Offset((f.Page - 1) * usersPerPage).Limit(usersPerPage).Find(&users)
+
+		admins := map[uint]bool{}
+		if len(users) > 0 {
+			ids := make([]uint, len(users))
+			for i, u := range users {
+				ids[i] = u.ID
+			}
+			var adminIDs []uint
+			db.Model(&model.UserRole{}).
+				Joins("JOIN roles ON roles.id = user_roles.role_id").
+				Where("user_roles.user_id IN ? AND roles.name = ?", ids, rbac.RoleAdmin).
+				Pluck("user_roles.user_id", &adminIDs)
+			for _, id := range adminIDs {
+				admins[id] = true
+			}
+		}
+
+		var loginMethods []string
+		db.Unscoped().Model(&model.User{}).Distinct("login_method").Order("login_method").Pluck("login_method", &loginMethods)
+
+		data := pageData(c, "Users")
+		data["Users"] = users
+		data["Admins"] = admins
+		data["Filter"] = f
+		data["LoginMethods"] = loginMethods
+		data["Total"] = total
+		if f.Page > 1 {
+			data["PrevURL"] = f.url(f.Page - 1)
+		}
+		if int64(f.Page*usersPerPage) < total {
+			data["NextURL"] = f.url(f.Page + 1)
+		}
+		c.HTML(http.StatusOK, "admin.users.html", data)
+	}
+}
+
+// findUser loads the user, deleted or not, named by the ":id" route
+// parameter.
+func findUser(c *gin.Context, db *gorm.DB) (model.User, bool) {
+	var user model.User
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || db.Unscoped().First(&user, id).Error != nil {
+		c.HTML(http.StatusNotFound, "error.html", gin.H{
+			"Title": "Not found",
+			"Error": "User not found",
+		})
+		return user, false
+	}
+	return user, true
+}
+
+// errCannotManage is shown when rbac.CanManage refuses an action.
+const errCannotManage = "Only administrators can change users with admin panel access"
+
+// canManage renders an error and returns false when the signed-in user may
+// not act on target; see rbac.CanManage.
+func canManage(c *gin.Context, db *gorm.DB, target model.User) bool {
+	actor := c.MustGet("user").(model.User)
+	if !rbac.CanManage(db, actor.ID, target.ID) {
+		renderUser(c, db, target, "", errCannotManage)
+		return false
+	}
+	return true
+}
+
+// renderUser renders the detail page for target with an optional message or
+// error.
+func renderUser(c *gin.Context, db *gorm.DB, target model.User, message, errMsg string) {
+	roles, _ := rbac.UserRoles(db, target.ID)
+	var sessions int64
+	db.Model(&model.Session{}).Where("user_id = ? AND expires_at > ?", target.ID, time.Now()).Count(&sessions)
+
+	data := pageData(c, target.Email)
+	data["Target"] = target
+	data["Roles"] = roles
+	data["IsAdmin"] = rbac.IsAdmin(db, target.ID)
+	data["Deleted"] = target.DeletedAt.Valid
+	data["ActiveSessions"] = sessions
+	data["Impersonable"] = !target.DeletedAt.Valid && !target.Suspended() && !rbac.Can(db, target.ID, rbac.PermAdminAccess)
+	if target.SuspendedByID != nil {
+		var suspendedBy model.User
+		if db.Unscoped().Select("email").First(&suspendedBy, *target.SuspendedByID).Error == nil {
+			data["SuspendedBy"] = suspendedBy.Email
+		}
+	}
+	if message != "" {
+		data["Message"] = message
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	c.HTML(http.StatusOK, "admin.user.html", data)
+}
+
+// User shows one user with their roles and the actions available on them.
+func User(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		renderUser(c, db, target, "", "")
+	}
+}
+
+// UpdateUser saves the posted username, email, name and bio.
+func UpdateUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+
+		username := strings.TrimSpace(c.PostForm("username"))
+		email := strings.TrimSpace(c.PostForm("email"))
+		if !utils.ValidateUsername(username) {
+			renderUser(c, db, target, "", "Invalid username")
+			return
+		}
+		if !utils.ValidateEmail(email) {
+			renderUser(c, db, target, "", "Invalid email address")
+			return
+		}
+		var taken int64
+		db.Unscoped().Model(&model.User{}).
+			Where("(username = ? OR email = ?) AND id <> ?", username, email, target.ID).
+			Count(&taken)
+		if taken > 0 {
+			renderUser(c, db, target, "", "Username or email already exists")
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if username != target.Username {
+			updates["username"] = username
+		}
+		if email != target.Email {
+			updates["email"] = email
+			// The new address has not been confirmed by its owner
+			updates["email_verified_at"] = nil
+		}
+		if name := utils.SanitizeString(c.PostForm("name"), 100); name != target.Name {
+			updates["name"] = name
+		}
+		if bio := utils.SanitizeString(c.PostForm("bio"), 500); bio != target.Bio {
+			updates["bio"] = bio
+		}
+		if len(updates) == 0 {
+			renderUser(c, db, target, "No changes to save.", "")
+			return
+		}
+
+		if err := db.Unscoped().Model(&target).Updates(updates).Error; err != nil {
+			renderUser(c, db, target, "", "Failed to update user")
+			return
+		}
+		fields := make([]string, 0, len(updates))
+		for k := range updates {
+			if k != "email_verified_at" {
+				fields = append(fields, k)
+			}
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionUserUpdated,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+			Metadata: map[string]interface{}{"fields": fields, "via": "admin"},
+		})
+		renderUser(c, db, target, "User updated.", "")
+	}
+}
+
+// ToggleAdmin gives the user the admin role, or removes it if they hold it.
+func ToggleAdmin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if target.DeletedAt.Valid {
+			renderUser(c, db, target, "", "Restore the user before changing their roles")
+			return
+		}
+
+		action, message := audit.ActionRoleAssigned, target.Email+" is now an administrator."
+		var err error
+		if rbac.IsAdmin(db, target.ID) {
+			action, message = audit.ActionRoleUnassigned, target.Email+" is no longer an administrator."
+			err = rbac.Unassign(db, target.ID, rbac.RoleAdmin)
+		} else {
+			err = rbac.Assign(db, target.ID, rbac.RoleAdmin)
+		}
+		if err != nil {
+			if errors.Is(err, rbac.ErrLastAdmin) {
+				renderUser(c, db, target, "", "At least one user must keep the "+rbac.RoleAdmin+" role")
+				return
+			}
+			renderUser(c, db, target, "", "Failed to update roles")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   action,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+			Metadata: map[string]interface{}{"role": rbac.RoleAdmin},
+		})
+		renderUser(c, db, target, message, "")
+	}
+}
+
+// DeleteUser soft-deletes the user and signs them out everywhere. Deleted
+// users cannot sign in but keep their data and can be restored.
+func DeleteUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+		if target.DeletedAt.Valid {
+			renderUser(c, db, target, "", "User is already deleted")
+			return
+		}
+		if target.ID == adminUser.ID {
+			renderUser(c, db, target, "", "You cannot delete your own account here")
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := rbac.RequireOtherAdmin(tx, target.ID); err != nil {
+				return err
+			}
+			if err := tx.Delete(&target).Error; err != nil {
+				return err
+			}
+			return sessionstore.RevokeUser(tx, target.ID)
+		})
+		if err != nil {
+			if errors.Is(err, rbac.ErrLastAdmin) {
+				renderUser(c, db, target, "", "The last administrator cannot be deleted")
+				return
+			}
+			renderUser(c, db, target, "", "Failed to delete user")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionUserDeleted,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+		})
+		db.Unscoped().First(&target, target.ID)
+		renderUser(c, db, target, target.Email+" has been deleted.", "")
+	}
+}
+
+// RestoreUser undoes a soft delete.
+func RestoreUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+		if !target.DeletedAt.Valid {
+			renderUser(c, db, target, "", "User is not deleted")
+			return
+		}
+
+		if err := db.Unscoped().Model(&target).Update("deleted_at", nil).Error; err != nil {
+			renderUser(c, db, target, "", "Failed to restore user")
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionUserRestored,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+		})
+		target.DeletedAt = gorm.DeletedAt{}
+		renderUser(c, db, target, target.Email+" has been restored.", "")
+	}
+}
+
+// ForcePasswordReset clears the user's password, signs them out everywhere
+// and emails them a reset link. They cannot sign in with a password until
+// they choose a new one.
+func ForcePasswordReset(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+		if target.DeletedAt.Valid {
+			renderUser(c, db, target, "", "Restore the user before resetting their password")
+			return
+		}
+
+		now := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&target).Updates(map[string]interface{}{
+				"password":            "",
+				"password_changed_at": now,
+			}).Error; err != nil {
+				return err
+			}
+			return sessionstore.RevokeUser(tx, target.ID)
+		})
+		if err != nil {
+			renderUser(c, db, target, "", "Failed to reset password")
+			return
+		}
+
+		message := "Password cleared and a reset link was sent to " + target.Email + "."
+		if err := index.SendPasswordReset(db, emailService, &target); err != nil {
+			utils.Logger.Error("Failed to send password reset", "err", err, "user_id", target.ID)
+			message = "Password cleared, but the reset email could not be sent. Ask the user to use Forgot password."
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionPasswordResetForced,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+		})
+		renderUser(c, db, target, message, "")
+	}
+}
+
+// Impersonate signs the admin in as the user so they can see the site as the
+// user does. The admin's own session is restored from the banner's Stop
+// button.
+func Impersonate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+		if target.DeletedAt.Valid {
+			renderUser(c, db, target, "", "Restore the user before impersonating them")
+			return
+		}
+		if target.Suspended() {
+			renderUser(c, db, target, "", "Lift the suspension before impersonating this user")
+			return
+		}
+
+		if err := impersonation.Start(c, db, adminUser, target); err != nil {
+			switch {
+			case errors.Is(err, impersonation.ErrSelf):
+				renderUser(c, db, target, "", "You cannot impersonate yourself")
+			case errors.Is(err, impersonation.ErrAdminTarget):
+				renderUser(c, db, target, "", "Users with admin panel access cannot be impersonated")
+			case errors.Is(err, impersonation.ErrActive):
+				renderUser(c, db, target, "", "Stop the current impersonation first")
+			default:
+				renderUser(c, db, target, "", "Failed to start impersonation")
+			}
+			return
+		}
+		c.Redirect(http.StatusFound, "/")
+	}
+}
+
+// SuspendUser blocks the user from signing in, either for a number of days or
+// until the suspension is lifted, and signs them out everywhere. Suspending a
+// suspended user replaces the suspension.
+func SuspendUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+		if target.DeletedAt.Valid {
+			renderUser(c, db, target, "", "Restore the user before suspending them")
+			return
+		}
+		if target.ID == adminUser.ID {
+			renderUser(c, db, target, "", "You cannot suspend your own account")
+			return
+		}
+
+		reason := strings.TrimSpace(c.PostForm("reason"))
+		if reason == "" || len(reason) > maxSuspensionReason {
+			renderUser(c, db, target, "", "Enter a reason of up to 500 characters")
+			return
+		}
+		days, err := strconv.Atoi(c.DefaultPostForm("days", "0"))
+		if err != nil || days < 0 || days > maxSuspensionDays {
+			renderUser(c, db, target, "", "Invalid suspension length")
+			return
+		}
+
+		now := time.Now()
+		var until *time.Time
+		if days > 0 {
+			t := now.AddDate(0, 0, days)
+			until = &t
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := rbac.RequireOtherAdmin(tx, target.ID); err != nil {
+				return err
+			}
+			if err := tx.Model(&target).Updates(map[string]interface{}{
+				"suspended_at":      now,
+				"suspended_until":   until,
+				"suspension_reason": reason,
+				"suspended_by_id":   adminUser.ID,
+			}).Error; err != nil {
+				return err
+			}
+			return sessionstore.RevokeUser(tx, target.ID)
+		})
+		if err != nil {
+			if errors.Is(err, rbac.ErrLastAdmin) {
+				renderUser(c, db, target, "", "The last administrator cannot be suspended")
+				return
+			}
+			renderUser(c, db, target, "", "Failed to suspend user")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionUserSuspended,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+			Metadata: map[string]interface{}{"reason": reason, "until": until},
+		})
+		target.SuspendedAt = &now
+		target.SuspendedUntil = until
+		target.SuspensionReason = reason
+		target.SuspendedByID = &adminUser.ID
+		renderUser(c, db, target, target.Email+" has been suspended and signed out.", "")
+	}
+}
+
+// UnsuspendUser lifts the user's suspension before it ends.
+func UnsuspendUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminUser := c.MustGet("user").(model.User)
+		target, ok := findUser(c, db)
+		if !ok {
+			return
+		}
+		if !canManage(c, db, target) {
+			return
+		}
+		if !target.Suspended() {
+			renderUser(c, db, target, "", "User is not suspended")
+			return
+		}
+
+		if err := db.Model(&target).Updates(map[string]interface{}{
+			"suspended_at":      nil,
+			"suspended_until":   nil,
+			"suspension_reason": "",
+			"suspended_by_id":   nil,
+		}).Error; err != nil {
+			renderUser(c, db, target, "", "Failed to lift suspension")
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionUserUnsuspended,
+			ActorID:  audit.ID(adminUser.ID),
+			TargetID: audit.ID(target.ID),
+		})
+		target.SuspendedAt = nil
+		target.SuspendedUntil = nil
+		target.SuspensionReason = ""
+		target.SuspendedByID = nil
+		renderUser(c, db, target, "The suspension of "+target.Email+" has been lifted.", "")
+	}
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+)
+
+func TestRestoreUser(t *testing.T) {
+	db := testDB(t)
+	admin := createUser(t, db, "admin", rbac.RoleAdmin)
+	moderator := createUser(t, db, "moderator", "moderator")
+	support := createUser(t, db, "support", "support")
+	member := createUser(t, db, "member")
+
+	deleted := func(id uint) bool {
+		var user model.User
+		db.Unscoped().First(&user, id)
+		return user.DeletedAt.Valid
+	}
+	path := func(u model.User) string { return fmt.Sprintf("/%d/restore", u.ID) }
+	db.Delete(&support)
+	db.Delete(&member)
+
+	if got := post(t, db, moderator, RestoreUser(db), path(support), nil); got != errCannotManage {
+		t.Errorf("moderator restoring support: %q, want %q", got, errCannotManage)
+	}
+	if !deleted(support.ID) {
+		t.Error("moderator restored a user with admin panel access")
+	}
+
+	post(t, db, moderator, RestoreUser(db), path(member), nil)
+	if deleted(member.ID) {
+		t.Error("moderator could not restore a member")
+	}
+	post(t, db, admin, RestoreUser(db), path(support), nil)
+	if deleted(support.ID) {
+		t.Error("admin could not restore support")
+	}
+}
+
+func TestUnsuspendUser(t *testing.T) {
+	db := testDB(t)
+	admin := createUser(t, db, "admin", rbac.RoleAdmin)
+	moderator := createUser(t, db, "moderator", "moderator")
+	support := createUser(t, db, "support", "support")
+
+	suspended := func() bool {
+		var user model.User
+		db.First(&user, support.ID)
+		return user.Suspended()
+	}
+	db.Model(&support).Update("suspended_at", time.Now())
+	path := fmt.Sprintf("/%d/unsuspend", support.ID)
+
+	if got := post(t, db, moderator, UnsuspendUser(db), path, nil); got != errCannotManage {
+		t.Errorf("moderator lifting a suspension: %q, want %q", got, errCannotManage)
+	}
+	if !suspended() {
+		t.Error("moderator lifted the suspension of a user with admin panel access")
+	}
+
+	post(t, db, admin, UnsuspendUser(db), path, nil)
+	if suspended() {
+		t.Error("admin could not lift the suspension")
+	}
+}
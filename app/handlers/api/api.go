@@ -0,0 +1,91 @@
+// Package api implements the versioned JSON API served under /api/v1.
+//
+// Successful responses wrap resources in {"data": ...}; lists add
+// "next_cursor" when more results follow. Errors use utils.ErrorResponse:
+// {"error": "not_found", "message": "..."}.
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Item is the envelope for a single resource.
+type Item struct {
+	Data interface{} `json:"data"`
+}
+
+// List is the envelope for a page of resources. NextCursor is empty on the
+// last page.
+type List struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// respondError ends the request with the error envelope for err.
+func respondError(c *gin.Context, err error) {
+	status := utils.ErrorToStatusCode(err)
+	if status >= http.StatusInternalServerError {
+		utils.Logger.Error("API request failed", "err", err, "method", c.Request.Method, "path", c.FullPath())
+	}
+	c.AbortWithStatusJSON(status, utils.NewErrorResponse(err))
+}
+
+// badRequest returns a 400 error with a message for the client.
+func badRequest(message string) error {
+	return utils.NewAppError(http.StatusBadRequest, message, utils.ErrBadRequest)
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// page is a cursor pagination request: up to Limit rows with IDs after
+// After, in ascending ID order.
+type page struct {
+	Limit int
+	After uint
+}
+
+// parsePage reads the "limit" and "cursor" query parameters.
+func parsePage(c *gin.Context) (page, error) {
+	p := page{Limit: defaultPageSize}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return p, badRequest("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		p.Limit = n
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return p, badRequest("Invalid cursor")
+		}
+		after, err := strconv.ParseUint(string(raw), 10, 64)
+		if err != nil {
+			return p, badRequest("Invalid cursor")
+		}
+		p.After = uint(after)
+	}
+	return p, nil
+}
+
+// encodeCursor returns the opaque cursor for the page after id.
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+// pathID parses the ":id" route parameter.
+func pathID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, utils.NewAppError(http.StatusNotFound, "Not found", utils.ErrNotFound)
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/apitoken"
+	"github.com/dariubs/scaffold/app/openapi"
+	"github.com/dariubs/scaffold/app/rbac"
+)
+
+var pageParams = []openapi.Param{
+	{Name: "limit", Type: "integer", Description: "Page size, 1-100 (default 20)"},
+	{Name: "cursor", Description: "next_cursor from the previous page"},
+}
+
+// Describe adds the /api/v1 account and user operations to spec.
+func Describe(spec *openapi.Spec) {
+	authErrors := []int{http.StatusUnauthorized, http.StatusForbidden}
+	adminErrors := []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound}
+
+	spec.Add(http.MethodGet, "/api/v1/me", openapi.Operation{
+		Summary:  "Get the authenticated user",
+		Tags:     []string{"Account"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeProfileRead},
+		Response: Item{Data: User{}},
+		Errors:   authErrors,
+	})
+	spec.Add(http.MethodPatch, "/api/v1/me", openapi.Operation{
+		Summary:  "Update the authenticated user's profile",
+		Tags:     []string{"Account"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeProfileWrite},
+		Body:     UpdateProfileRequest{},
+		Response: Item{Data: User{}},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict},
+	})
+
+	spec.Add(http.MethodGet, "/api/v1/users", openapi.Operation{
+		Summary:     "List users",
+		Description: "Filter with q, which matches username, email or name.",
+		Tags:        []string{"Users"},
+		Session:     true,
+		Scopes:      []string{apitoken.ScopeAdmin},
+		Permission:  rbac.PermUsersView,
+		Query:       append([]openapi.Param{{Name: "q", Description: "Search text"}}, pageParams...),
+		Response:    List{Data: []User{}},
+		Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+	})
+	spec.Add(http.MethodGet, "/api/v1/users/:id", openapi.Operation{
+		Summary:    "Get a user",
+		Tags:       []string{"Users"},
+		Session:    true,
+		Scopes:     []string{apitoken.ScopeAdmin},
+		Permission: rbac.PermUsersView,
+		Response:   Item{Data: User{}},
+		Errors:     adminErrors,
+	})
+	spec.Add(http.MethodPatch, "/api/v1/users/:id", openapi.Operation{
+		Summary:    "Update a user's profile",
+		Tags:       []string{"Users"},
+		Session:    true,
+		Scopes:     []string{apitoken.ScopeAdmin},
+		Permission: rbac.PermUsersEdit,
+		Body:       UpdateProfileRequest{},
+		Response:   Item{Data: User{}},
+		Errors:     append(adminErrors, http.StatusBadRequest, http.StatusConflict),
+	})
+	spec.Add(http.MethodDelete, "/api/v1/users/:id/sessions", openapi.Operation{
+		Summary:    "Sign a user out of every session",
+		Tags:       []string{"Users"},
+		Session:    true,
+		Scopes:     []string{apitoken.ScopeAdmin},
+		Permission: rbac.PermSessionsRevoke,
+		Status:     http.StatusNoContent,
+		Errors:     adminErrors,
+	})
+}
+
+// DescribeUploads adds the /api/v1 upload operations, which are only
+// registered when file storage is available, to spec.
+func DescribeUploads(spec *openapi.Spec) {
+	imageForm := []openapi.Param{{Name: "image", Type: "file", Required: true}}
+	spec.Add(http.MethodPost, "/api/v1/me/avatar", openapi.Operation{
+		Summary:  "Replace the authenticated user's profile image",
+		Tags:     []string{"Uploads"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeUploads},
+		Form:     imageForm,
+		Response: Item{Data: Upload{}},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestEntityTooLarge},
+	})
+	spec.Add(http.MethodPost, "/api/v1/uploads", openapi.Operation{
+		Summary:  "Upload an image",
+		Tags:     []string{"Uploads"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeUploads},
+		Form:     append(imageForm, openapi.Param{Name: "folder", Description: `Destination folder (default "general")`}),
+		Response: Item{Data: Upload{}},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestEntityTooLarge},
+	})
+	spec.Add(http.MethodGet, "/api/v1/uploads", openapi.Operation{
+		Summary:  "List the authenticated user's files",
+		Tags:     []string{"Uploads"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeUploads},
+		Query:    append([]openapi.Param{{Name: "folder", Description: "Only list files in this folder"}}, pageParams...),
+		Response: List{Data: []Upload{}},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+	})
+	spec.Add(http.MethodDelete, "/api/v1/uploads", openapi.Operation{
+		Summary: "Delete one of the authenticated user's files",
+		Tags:    []string{"Uploads"},
+		Session: true,
+		Scopes:  []string{apitoken.ScopeUploads},
+		Body:    DeleteUploadRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	})
+}
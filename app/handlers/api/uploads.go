@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/storage"
+	"github.com/dariubs/scaffold/app/uploads"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Upload is the API representation of a stored file.
+type Upload struct {
+	ID          uint   `json:"id"`
+	URL         string `json:"url"`
+	Folder      string `json:"folder"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	Checksum    string `json:"checksum"` // Hex-encoded SHA-256
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	// Variants maps each size to the URL of the resized copy that fits in a
+	// box of that many pixels.
+	Variants  map[int]string `json:"variants,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func newUpload(store storage.Storage, u model.Upload) Upload {
+	out := Upload{
+		ID:          u.ID,
+		URL:         store.URL(u.Key),
+		Folder:      u.Folder,
+		Size:        u.Size,
+		ContentType: u.ContentType,
+		Checksum:    u.Checksum,
+		Width:       u.Width,
+		Height:      u.Height,
+		CreatedAt:   u.CreatedAt,
+	}
+	if sizes := u.VariantSizes(); len(sizes) > 0 {
+		out.Variants = make(map[int]string, len(sizes))
+		for _, size := range sizes {
+			out.Variants[size] = store.URL(u.VariantKey(size))
+		}
+	}
+	return out
+}
+
+// DeleteUploadRequest is the body of DELETE /uploads.
+type DeleteUploadRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// imageFile returns the uploaded file in the "image" form field. Its type and
+// size are checked by uploads.Save.
+func imageFile(c *gin.Context) (*multipart.FileHeader, error) {
+	file, err := c.FormFile("image")
+	if err != nil {
+		return nil, badRequest("No file uploaded in the image field")
+	}
+	return file, nil
+}
+
+// saveError returns the API error for a failed uploads.Save.
+func saveError(err error) error {
+	var invalid *uploads.ValidationError
+	switch {
+	case errors.As(err, &invalid) && errors.Is(err, uploads.ErrTooLarge):
+		return utils.NewAppError(http.StatusRequestEntityTooLarge, invalid.Message, err)
+	case errors.As(err, &invalid):
+		return badRequest(invalid.Message)
+	case errors.Is(err, storage.ErrInvalidFolder):
+		return badRequest("Invalid folder. Use letters, digits, dashes and underscores")
+	}
+	return utils.NewAppError(http.StatusBadGateway, "Failed to upload file", err)
+}
+
+// UploadAvatar replaces the authenticated user's profile image.
+func UploadAvatar(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		file, err := imageFile(c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		upload, err := uploads.Save(c.Request.Context(), db, store, user.ID, file, "profiles")
+		if err != nil {
+			respondError(c, saveError(err))
+			return
+		}
+		fileURL := store.URL(upload.Key)
+		oldURL := user.AvatarURL
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"avatar_url":      fileURL,
+			"avatar_variants": upload.Variants,
+		}).Error; err != nil {
+			respondError(c, err)
+			return
+		}
+		if oldURL != "" {
+			if err := uploads.Discard(c.Request.Context(), db, store, user.ID, oldURL); err != nil {
+				utils.Logger.Warn("Failed to delete old profile image", "err", err, "user_id", user.ID)
+			}
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionAvatarChanged,
+			ActorID:  audit.ID(user.ID),
+			TargetID: audit.ID(user.ID),
+			Metadata: map[string]interface{}{"url": fileURL, "via": "api"},
+		})
+		c.JSON(http.StatusCreated, Item{Data: newUpload(store, upload)})
+	}
+}
+
+// UploadImage stores an image in the folder given by the "folder" form
+// field, "general" by default.
+func UploadImage(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		file, err := imageFile(c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		folder := c.PostForm("folder")
+		if folder == "" {
+			folder = "general"
+		}
+
+		upload, err := uploads.Save(c.Request.Context(), db, store, user.ID, file, folder)
+		if err != nil {
+			respondError(c, saveError(err))
+			return
+		}
+		c.JSON(http.StatusCreated, Item{Data: newUpload(store, upload)})
+	}
+}
+
+// ListUploads lists the authenticated user's files, oldest first, optionally
+// filtered by the "folder" query parameter.
+func ListUploads(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		p, err := parsePage(c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		query := db.Where("user_id = ? AND id > ?", user.ID, p.After)
+		if folder := c.Query("folder"); folder != "" {
+			query = query.Where("folder = ?", folder)
+		}
+		var rows []model.Upload
+		if err := query.Order("id").Limit(p.Limit + 1).Find(&rows).Error; err != nil {
+			respondError(c, err)
+			return
+		}
+
+		resp := List{}
+		if len(rows) > p.Limit {
+			rows = rows[:p.Limit]
+			resp.NextCursor = encodeCursor(rows[len(rows)-1].ID)
+		}
+		out := make([]Upload, len(rows))
+		for i, u := range rows {
+			out[i] = newUpload(store, u)
+		}
+		resp.Data = out
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// DeleteImage deletes one of the authenticated user's files by URL.
+func DeleteImage(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DeleteUploadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, badRequest("Body must be JSON with a url field"))
+			return
+		}
+		user := c.MustGet("user").(model.User)
+		upload, err := uploads.DeleteOwned(c.Request.Context(), db, store, user.ID, req.URL)
+		switch {
+		case errors.Is(err, uploads.ErrNotFound):
+			respondError(c, utils.NewAppError(http.StatusNotFound, "File not found", utils.ErrNotFound))
+			return
+		case errors.Is(err, uploads.ErrNotOwner):
+			respondError(c, utils.NewAppError(http.StatusForbidden, "You can only delete your own files", utils.ErrForbidden))
+			return
+		case err != nil:
+			respondError(c, utils.NewAppError(http.StatusBadGateway, "Failed to delete file", err))
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionFileDeleted,
+			ActorID:  audit.Actor(c),
+			TargetID: audit.ID(upload.UserID),
+			Metadata: map[string]interface{}{"url": req.URL, "upload_id": upload.ID, "via": "api"},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
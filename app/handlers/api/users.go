@@ -0,0 +1,261 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// User is the API representation of an account. Roles and LockedUntil are
+// only included in admin responses.
+type User struct {
+	ID               uint           `json:"id"`
+	Username         string         `json:"username"`
+	Email            string         `json:"email"`
+	Name             string         `json:"name"`
+	AvatarURL        string         `json:"avatar_url"`
+	AvatarURLs       map[int]string `json:"avatar_urls,omitempty"` // Resized copies by size
+	Bio              string         `json:"bio"`
+	LoginMethod      string         `json:"login_method"`
+	EmailVerified    bool           `json:"email_verified"`
+	TwoFactorEnabled bool           `json:"two_factor_enabled"`
+	CreatedAt        time.Time      `json:"created_at"`
+	Roles            []string       `json:"roles,omitempty"`
+	LockedUntil      *time.Time     `json:"locked_until,omitempty"`
+}
+
+func newUser(u model.User) User {
+	return User{
+		ID:               u.ID,
+		Username:         u.Username,
+		Email:            u.Email,
+		Name:             u.Name,
+		AvatarURL:        u.AvatarURL,
+		AvatarURLs:       u.AvatarURLs(),
+		Bio:              u.Bio,
+		LoginMethod:      u.LoginMethod,
+		EmailVerified:    u.EmailVerified(),
+		TwoFactorEnabled: u.TwoFactorEnabled(),
+		CreatedAt:        u.CreatedAt,
+	}
+}
+
+// newAdminUser adds the fields only administrators see.
+func newAdminUser(db *gorm.DB, u model.User) User {
+	out := newUser(u)
+	roles, _ := rbac.UserRoles(db, u.ID)
+	for _, r := range roles {
+		out.Roles = append(out.Roles, r.Name)
+	}
+	if u.Locked() {
+		out.LockedUntil = u.LockedUntil
+	}
+	return out
+}
+
+// UpdateProfileRequest is the body of PATCH /me and PATCH /users/:id.
+// Omitted fields are left unchanged.
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	Name     *string `json:"name"`
+	Bio      *string `json:"bio"`
+}
+
+// apply validates req and returns the column updates it makes to user.
+func (req UpdateProfileRequest) apply(db *gorm.DB, user model.User) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if !utils.ValidateUsername(username) {
+			return nil, utils.NewAppError(http.StatusBadRequest, "Invalid username", utils.ErrValidationFailed)
+		}
+		var taken int64
+		db.Model(&model.User{}).Where("username = ? AND id <> ?", username, user.ID).Count(&taken)
+		if taken > 0 {
+			return nil, utils.NewAppError(http.StatusConflict, "Username is already taken", nil)
+		}
+		updates["username"] = username
+	}
+	if req.Name != nil {
+		updates["name"] = utils.SanitizeString(*req.Name, 100)
+	}
+	if req.Bio != nil {
+		updates["bio"] = utils.SanitizeString(*req.Bio, 500)
+	}
+	return updates, nil
+}
+
+// Me returns the authenticated user.
+func Me() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		c.JSON(http.StatusOK, Item{Data: newUser(user)})
+	}
+}
+
+// UpdateMe updates the authenticated user's profile.
+func UpdateMe(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		var req UpdateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, badRequest("Invalid JSON body"))
+			return
+		}
+
+		updates, err := req.apply(db, user)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, Item{Data: newUser(user)})
+	}
+}
+
+// ListUsers returns a page of users, optionally filtered by the "q" query
+// parameter matching username, email or name.
+func ListUsers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := parsePage(c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		query := db.Model(&model.User{}).Where("id > ?", p.After)
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			like := "%" + strings.ToLower(q) + "%"
+			query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ? OR LOWER(name) LIKE ?", like, like, like)
+		}
+		var users []model.User
+		if err := query.Order("id").Limit(p.Limit + 1).Find(&users).Error; err != nil {
+			respondError(c, err)
+			return
+		}
+
+		resp := List{}
+		if len(users) > p.Limit {
+			users = users[:p.Limit]
+			resp.NextCursor = encodeCursor(users[len(users)-1].ID)
+		}
+		out := make([]User, len(users))
+		for i, u := range users {
+			out[i] = newAdminUser(db, u)
+		}
+		resp.Data = out
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// findUser loads the user named by the ":id" route parameter.
+func findUser(c *gin.Context, db *gorm.DB) (model.User, error) {
+	var user model.User
+	id, err := pathID(c)
+	if err != nil {
+		return user, err
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, utils.NewAppError(http.StatusNotFound, "User not found", utils.ErrNotFound)
+		}
+		return user, err
+	}
+	return user, nil
+}
+
+// GetUser returns one user.
+func GetUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := findUser(c, db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, Item{Data: newAdminUser(db, user)})
+	}
+}
+
+// UpdateUser updates another user's profile. Users with admin panel access
+// can only be updated by administrators and users holding roles.manage.
+func UpdateUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := findUser(c, db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !rbac.CanManage(db, c.GetUint("user_id"), user.ID) {
+			respondError(c, utils.NewAppError(http.StatusForbidden, "Only administrators can change users with admin panel access", utils.ErrForbidden))
+			return
+		}
+		var req UpdateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, badRequest("Invalid JSON body"))
+			return
+		}
+
+		updates, err := req.apply(db, user)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				respondError(c, err)
+				return
+			}
+			fields := make([]string, 0, len(updates))
+			for k := range updates {
+				fields = append(fields, k)
+			}
+			audit.Record(db, c, audit.Event{
+				Action:   audit.ActionUserUpdated,
+				ActorID:  audit.ID(c.GetUint("user_id")),
+				TargetID: audit.ID(user.ID),
+				Metadata: map[string]interface{}{"fields": fields, "via": "api"},
+			})
+		}
+		c.JSON(http.StatusOK, Item{Data: newAdminUser(db, user)})
+	}
+}
+
+// RevokeUserSessions signs a user out of every session.
+func RevokeUserSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := findUser(c, db)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !rbac.CanManage(db, c.GetUint("user_id"), user.ID) {
+			respondError(c, utils.NewAppError(http.StatusForbidden, "Only administrators can change users with admin panel access", utils.ErrForbidden))
+			return
+		}
+		if err := sessionstore.RevokeUser(db, user.ID); err != nil {
+			respondError(c, err)
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionSessionsRevoked,
+			ActorID:  audit.Actor(c),
+			TargetID: audit.ID(user.ID),
+			Metadata: map[string]interface{}{"via": "api"},
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
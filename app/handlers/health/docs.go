@@ -0,0 +1,28 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/openapi"
+)
+
+// Status is the body returned by the health and readiness checks.
+type Status struct {
+	Status string `json:"status"`          // "ok", "ready" or "unhealthy"
+	Error  string `json:"error,omitempty"` // Why the app is not ready
+}
+
+// Describe adds the health check operations to spec.
+func Describe(spec *openapi.Spec) {
+	spec.Add(http.MethodGet, "/health", openapi.Operation{
+		Summary:  "Liveness check",
+		Tags:     []string{"Health"},
+		Response: Status{},
+	})
+	spec.Add(http.MethodGet, "/readiness", openapi.Operation{
+		Summary:     "Readiness check",
+		Description: "Returns 503 with the same body when the database is unreachable.",
+		Tags:        []string{"Health"},
+		Response:    Status{},
+	})
+}
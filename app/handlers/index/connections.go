@@ -0,0 +1,241 @@
+package index
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/oauth"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// completeOAuth finishes a provider callback. When the user started a link
+// from their profile the identity is connected to the signed-in account;
+// otherwise the identity's owner is signed in, and an unknown identity gets a
+// new account. An existing account is never claimed because its email
+// matches: the owner has to sign in and connect the provider explicitly.
+func completeOAuth(c *gin.Context, db *gorm.DB, provider oauth.Provider, id oauth.Identity) {
+	session := sessions.Default(c)
+	if linkProvider, _ := session.Get("oauth_link").(string); linkProvider != "" {
+		session.Delete("oauth_link")
+		session.Save()
+		if linkProvider == provider.Name() {
+			linkOAuthIdentity(c, db, provider, id)
+			return
+		}
+	}
+
+	var identity model.OAuthIdentity
+	err := db.Where("provider = ? AND subject = ?", provider.Name(), id.Subject).First(&identity).Error
+	if err == nil {
+		var user model.User
+		if err := db.First(&user, identity.UserID).Error; err != nil {
+			c.Redirect(http.StatusFound, "/login?error=user")
+			return
+		}
+		finishLogin(c, db, &user, "oauth:"+provider.Name())
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Redirect(http.StatusFound, "/login?error=lookup")
+		return
+	}
+
+	var existing int64
+	db.Model(&model.User{}).Where("email = ?", id.Email).Count(&existing)
+	if existing > 0 {
+		renderLoginMessage(c, http.StatusOK, "Error",
+			"An account with this email already exists. Sign in with your usual method, then connect "+provider.Label()+" from your profile.")
+		return
+	}
+
+	user := model.User{
+		Username:    id.Username,
+		Email:       id.Email,
+		Password:    "", // No password for OAuth users
+		Name:        id.Name,
+		AvatarURL:   id.AvatarURL,
+		LoginMethod: provider.Name(),
+	}
+	var taken int64
+	db.Model(&model.User{}).Where("username = ?", user.Username).Count(&taken)
+	if user.Username == "" || taken > 0 {
+		user.Username = provider.Name() + "_" + id.Subject
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.OAuthIdentity{
+			UserID:   user.ID,
+			Provider: provider.Name(),
+			Subject:  id.Subject,
+			Email:    id.Email,
+		}).Error
+	})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/login?error=create")
+		return
+	}
+	finishLogin(c, db, &user, "oauth:"+provider.Name())
+}
+
+// linkOAuthIdentity connects id to the signed-in user.
+func linkOAuthIdentity(c *gin.Context, db *gorm.DB, provider oauth.Provider, id oauth.Identity) {
+	userID, _ := sessions.Default(c).Get("user_id").(uint)
+	if userID == 0 {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	var existing model.OAuthIdentity
+	if db.Where("provider = ? AND subject = ?", provider.Name(), id.Subject).First(&existing).Error == nil {
+		if existing.UserID != userID {
+			c.Redirect(http.StatusFound, "/profile?error=identity_in_use")
+			return
+		}
+		c.Redirect(http.StatusFound, "/profile")
+		return
+	}
+
+	err := db.Create(&model.OAuthIdentity{
+		UserID:   userID,
+		Provider: provider.Name(),
+		Subject:  id.Subject,
+		Email:    id.Email,
+	}).Error
+	if err != nil {
+		c.Redirect(http.StatusFound, "/profile?error=link_failed")
+		return
+	}
+
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionIdentityLinked,
+		ActorID:  audit.ID(userID),
+		TargetID: audit.ID(userID),
+		Metadata: map[string]interface{}{"provider": provider.Name()},
+	})
+	c.Redirect(http.StatusFound, "/profile?connected="+provider.Name())
+}
+
+// loginMethodCount returns how many independent ways user can currently sign
+// in: a password, each connected provider, each passkey, and email links.
+func loginMethodCount(db *gorm.DB, user *model.User) int64 {
+	var count int64
+	if names := providerNames(oauth.Enabled()); len(names) > 0 {
+		db.Model(&model.OAuthIdentity{}).Where("user_id = ? AND provider IN ?", user.ID, names).Count(&count)
+	}
+	if settings.Bool(settings.LoginPassword) && user.Password != "" {
+		count++
+	}
+	if settings.PasskeyEnabled() {
+		var passkeys int64
+		db.Model(&model.PasskeyCredential{}).Where("user_id = ?", user.ID).Count(&passkeys)
+		count += passkeys
+	}
+	if settings.Bool(settings.LoginMagicLink) && user.EmailVerified() {
+		count++
+	}
+	return count
+}
+
+func providerNames(providers []oauth.Provider) []string {
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// oauthConnection is one row of the connected accounts list on the profile.
+type oauthConnection struct {
+	oauth.Provider
+	Identity *model.OAuthIdentity
+}
+
+// oauthConnections lists every enabled provider with the user's identity for
+// it, if connected.
+func oauthConnections(db *gorm.DB, userID uint) []oauthConnection {
+	var identities []model.OAuthIdentity
+	db.Where("user_id = ?", userID).Find(&identities)
+
+	var connections []oauthConnection
+	for _, p := range oauth.Enabled() {
+		conn := oauthConnection{Provider: p}
+		for i := range identities {
+			if identities[i].Provider == p.Name() {
+				conn.Identity = &identities[i]
+			}
+		}
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// LinkOAuthProvider starts connecting another provider to the logged-in
+// account. The provider's callback sees the pending link in the session.
+func LinkOAuthProvider(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		provider, ok := oauth.Get(c.Param("provider"))
+		if !ok {
+			c.Redirect(http.StatusFound, "/profile?error=provider")
+			return
+		}
+
+		var count int64
+		db.Model(&model.OAuthIdentity{}).Where("user_id = ? AND provider = ?", user.ID, provider.Name()).Count(&count)
+		if count > 0 {
+			c.Redirect(http.StatusFound, "/profile")
+			return
+		}
+
+		session := sessions.Default(c)
+		session.Set("oauth_link", provider.Name())
+		if err := session.Save(); err != nil {
+			c.Redirect(http.StatusFound, "/profile?error=link_failed")
+			return
+		}
+		c.Redirect(http.StatusFound, "/auth/"+provider.Name())
+	}
+}
+
+// UnlinkOAuthProvider disconnects a provider from the logged-in account,
+// unless it is the account's last way to sign in.
+func UnlinkOAuthProvider(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		name := c.Param("provider")
+
+		var identity model.OAuthIdentity
+		if err := db.Where("user_id = ? AND provider = ?", user.ID, name).First(&identity).Error; err != nil {
+			c.Redirect(http.StatusFound, "/profile")
+			return
+		}
+
+		remaining := loginMethodCount(db, &user)
+		if _, enabled := oauth.Get(name); enabled {
+			remaining--
+		}
+		if remaining < 1 {
+			c.Redirect(http.StatusFound, "/profile?error=last_login_method")
+			return
+		}
+
+		if err := db.Delete(&identity).Error; err != nil {
+			c.Redirect(http.StatusFound, "/profile?error=unlink_failed")
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionIdentityUnlinked,
+			ActorID:  audit.ID(user.ID),
+			TargetID: audit.ID(user.ID),
+			Metadata: map[string]interface{}{"provider": name},
+		})
+		c.Redirect(http.StatusFound, "/profile?disconnected="+name)
+	}
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/apitoken"
+	"github.com/dariubs/scaffold/app/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// APIDocs renders an interactive viewer for the OpenAPI document.
+func APIDocs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, "api_docs.html", gin.H{
+			"Title":   "API Reference",
+			"SpecURL": "/openapi.json",
+		})
+	}
+}
+
+// UploadResponse is the body returned by the upload handlers.
+type UploadResponse struct {
+	Message  string `json:"message"`
+	ImageURL string `json:"image_url"`
+	// ImageURLs maps sizes to resized copies of a profile image
+	ImageURLs map[int]string `json:"image_urls,omitempty"`
+	Folder    string         `json:"folder,omitempty"`
+}
+
+// MessageResponse is the body returned by DeleteImage.
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// DescribeUploads adds the upload routes, which return JSON, to spec.
+func DescribeUploads(spec *openapi.Spec) {
+	errors := []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError}
+	spec.Add(http.MethodPost, "/upload/profile-image", openapi.Operation{
+		Summary:  "Replace the profile image (max 5MB)",
+		Tags:     []string{"Uploads"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeUploads},
+		Form:     []openapi.Param{{Name: "profile_image", Type: "file", Required: true}},
+		Response: UploadResponse{},
+		Errors:   errors,
+	})
+	spec.Add(http.MethodPost, "/upload/image", openapi.Operation{
+		Summary:  "Upload an image (max 10MB)",
+		Tags:     []string{"Uploads"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeUploads},
+		Query:    []openapi.Param{{Name: "folder", Description: `Destination folder (default "general")`}},
+		Form:     []openapi.Param{{Name: "image", Type: "file", Required: true}},
+		Response: UploadResponse{},
+		Errors:   errors,
+	})
+	spec.Add(http.MethodPost, "/delete/image", openapi.Operation{
+		Summary:  "Delete one of your uploaded images",
+		Tags:     []string{"Uploads"},
+		Session:  true,
+		Scopes:   []string{apitoken.ScopeUploads},
+		Form:     []openapi.Param{{Name: "image_url", Required: true}},
+		Response: MessageResponse{},
+		Errors:   append([]int{http.StatusNotFound}, errors...),
+	})
+}
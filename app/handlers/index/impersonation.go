@@ -0,0 +1,36 @@
+package index
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dariubs/scaffold/app/impersonation"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// addImpersonator adds the admin impersonating the session's user, if any, to
+// data so the page shows the impersonation banner.
+func addImpersonator(c *gin.Context, db *gorm.DB, data gin.H) {
+	if admin, ok := c.Get(impersonation.ContextImpersonator); ok {
+		data["Impersonator"] = admin
+		return
+	}
+	if admin, _ := impersonation.Impersonator(c, db); admin != nil {
+		data["Impersonator"] = *admin
+	}
+}
+
+// StopImpersonating switches an impersonated session back to the admin and
+// returns them to the admin page of the user they were impersonating.
+func StopImpersonating(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, targetID, err := impersonation.Stop(c, db)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/")
+			return
+		}
+		c.Redirect(http.StatusFound, "/"+settings.String(settings.AdminPath)+"/users/"+strconv.FormatUint(uint64(targetID), 10))
+	}
+}
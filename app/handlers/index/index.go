@@ -2,9 +2,14 @@ package index
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/dariubs/scaffold/app/apitoken"
+	"github.com/dariubs/scaffold/app/audit"
 	"github.com/dariubs/scaffold/app/config"
 	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/oauth"
+	"github.com/dariubs/scaffold/app/settings"
 	"github.com/dariubs/scaffold/app/utils"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -14,11 +19,10 @@ import (
 
 func loginFormData() gin.H {
 	return gin.H{
-		"LoginPassword": config.C.Login.PasswordEnabled,
-		"LoginGoogle":   config.C.OAuthGoogleEnabled(),
-		"LoginGitHub":  config.C.OAuthGitHubEnabled(),
-		"LoginLinkedIn": config.C.OAuthLinkedInEnabled(),
-		"LoginX":       config.C.OAuthXEnabled(),
+		"LoginPassword":  settings.Bool(settings.LoginPassword),
+		"OAuthProviders": oauth.Enabled(),
+		"LoginPasskey":   settings.PasskeyEnabled(),
+		"LoginMagicLink": settings.Bool(settings.LoginMagicLink),
 	}
 }
 
@@ -32,10 +36,13 @@ func Home(db *gorm.DB) gin.HandlerFunc {
 			db.First(&user, userID)
 		}
 
-		c.HTML(http.StatusOK, "home.html", gin.H{
-			"User":  user,
-			"Title": "Welcome to Scaffold",
-		})
+		data := gin.H{
+			"User":      user,
+			"Title":     "Welcome to Scaffold",
+			"CSRFToken": c.GetString("csrf_token"),
+		}
+		addImpersonator(c, db, data)
+		c.HTML(http.StatusOK, "home.html", data)
 	}
 }
 
@@ -48,13 +55,16 @@ func LoginForm() gin.HandlerFunc {
 		if errMsg := c.Query("error"); errMsg != "" {
 			data["Error"] = "Login failed. Please try again."
 		}
+		if c.Query("reset") != "" {
+			data["Message"] = "Your password has been reset. Please sign in."
+		}
 		c.HTML(http.StatusOK, "login.html", data)
 	}
 }
 
-func Login(db *gorm.DB) gin.HandlerFunc {
+func Login(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !config.C.Login.PasswordEnabled {
+		if !settings.Bool(settings.LoginPassword) {
 			c.Redirect(http.StatusFound, "/login?error=password_disabled")
 			return
 		}
@@ -69,22 +79,37 @@ func Login(db *gorm.DB) gin.HandlerFunc {
 			c.HTML(http.StatusOK, "login.html", data)
 		}
 
+		if ipBlocked(db, c.ClientIP()) {
+			renderLoginError("Too many failed sign-in attempts from your network. Please try again later.")
+			return
+		}
+
 		var user model.User
 		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			recordLoginAttempt(db, c, username, nil, false)
 			renderLoginError("Invalid username or password")
 			return
 		}
 
+		if msg := loginThrottled(&user); msg != "" {
+			renderLoginError(msg)
+			return
+		}
+
 		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			recordLoginAttempt(db, c, username, &user.ID, false)
+			registerLoginFailure(db, c, emailService, &user)
+			if user.Locked() {
+				renderLoginError(loginThrottled(&user))
+				return
+			}
 			renderLoginError("Invalid username or password")
 			return
 		}
 
-		session := sessions.Default(c)
-		session.Set("user_id", user.Model.ID)
-		session.Save()
-
-		c.Redirect(http.StatusFound, "/")
+		recordLoginAttempt(db, c, username, &user.ID, true)
+		clearLoginFailures(db, &user)
+		finishLogin(c, db, &user, "password")
 	}
 }
 
@@ -149,24 +174,71 @@ func Register(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
 			}()
 		}
 
+		// Send email verification link (non-blocking)
+		go func() {
+			if err := sendEmailVerification(db, emailService, &user); err != nil {
+				utils.Logger.Error("Failed to send verification email", "err", err, "user_id", user.ID)
+			}
+		}()
+
 		// Auto-login after registration
-		session := sessions.Default(c)
-		session.Set("user_id", user.Model.ID)
-		session.Save()
+		logIn(c, db, &user, "register", "")
 
+		if config.C.Auth.RequireEmailVerification {
+			c.Redirect(http.StatusFound, "/verify-email")
+			return
+		}
 		c.Redirect(http.StatusFound, "/")
 	}
 }
 
+// logIn starts an authenticated session for user and records the login.
+// method names how the user signed in, e.g. "password" or "oauth:github";
+// secondFactor is "totp" or "passkey" when a second factor was checked. The
+// login time is kept so sessions issued before a password change can be
+// rejected.
+func logIn(c *gin.Context, db *gorm.DB, user *model.User, method, secondFactor string) {
+	session := sessions.Default(c)
+	session.Delete("2fa_user_id")
+	session.Delete("2fa_started_at")
+	session.Delete("2fa_method")
+	session.Set("user_id", user.ID)
+	session.Set("auth_time", time.Now().Unix())
+	session.Save()
+
+	metadata := map[string]interface{}{"method": method}
+	if secondFactor != "" {
+		metadata["second_factor"] = secondFactor
+	}
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionLoginSucceeded,
+		ActorID:  audit.ID(user.ID),
+		TargetID: audit.ID(user.ID),
+		Metadata: metadata,
+	})
+}
+
 func Logout() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
 		session.Clear()
+		session.Options(sessions.Options{Path: "/", MaxAge: -1})
 		session.Save()
 		c.Redirect(http.StatusFound, "/")
 	}
 }
 
+// profileErrors maps the error codes that profile actions redirect with to
+// the message shown on the profile page.
+var profileErrors = map[string]string{
+	"sessions":          "Failed to sign out your other sessions.",
+	"provider":          "That sign-in provider is not available.",
+	"identity_in_use":   "That account is already connected to a different user.",
+	"link_failed":       "Failed to connect the account. Please try again.",
+	"unlink_failed":     "Failed to disconnect the account. Please try again.",
+	"last_login_method": "You cannot disconnect your only way to sign in. Set up another sign-in method first.",
+}
+
 func Profile(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user from context (set by auth middleware)
@@ -182,9 +254,50 @@ func Profile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		c.HTML(http.StatusOK, "profile.html", gin.H{
-			"User":  userModel,
-			"Title": "Profile",
-		})
+		data := profileData(c, db, userModel)
+		if provider, ok := oauth.Get(c.Query("connected")); ok {
+			data["Message"] = provider.Label() + " is now connected to your account."
+		}
+		if provider, ok := oauth.Get(c.Query("disconnected")); ok {
+			data["Message"] = provider.Label() + " has been disconnected."
+		}
+		if c.Query("token_revoked") != "" {
+			data["Message"] = "The access token has been revoked."
+		}
+		if msg, ok := profileErrors[c.Query("error")]; ok {
+			data["Error"] = msg
+		}
+
+		c.HTML(http.StatusOK, "profile.html", data)
+	}
+}
+
+// profileData builds the template data for the profile page.
+func profileData(c *gin.Context, db *gorm.DB, userModel model.User) gin.H {
+	data := gin.H{
+		"User":      userModel,
+		"Title":     "Profile",
+		"CSRFToken": c.GetString("csrf_token"),
+	}
+	addImpersonator(c, db, data)
+	var activeSessions []model.Session
+	db.Where("user_id = ? AND expires_at > ?", userModel.ID, time.Now()).
+		Order("last_seen_at DESC").Find(&activeSessions)
+	data["Sessions"] = activeSessions
+	data["CurrentSessionID"] = sessions.Default(c).ID()
+
+	data["Connections"] = oauthConnections(db, userModel.ID)
+
+	var tokens []model.PersonalAccessToken
+	db.Where("user_id = ?", userModel.ID).Order("created_at DESC").Find(&tokens)
+	data["AccessTokens"] = tokens
+	data["TokenScopes"] = apitoken.Scopes
+
+	if settings.PasskeyEnabled() {
+		var passkeys []model.PasskeyCredential
+		db.Where("user_id = ?", userModel.ID).Order("created_at").Find(&passkeys)
+		data["PasskeysEnabled"] = true
+		data["Passkeys"] = passkeys
 	}
+	return data
 }
@@ -0,0 +1,220 @@
+package index
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// unlockTokenTTL is how long the unlock link in a lockout email stays valid.
+const unlockTokenTTL = 24 * time.Hour
+
+// loginDelayAfter is the number of consecutive failures after which each
+// further attempt must wait progressively longer.
+const loginDelayAfter = 2
+
+// loginDelay returns how long the account must wait after its last failure
+// before another attempt is accepted: 1s, 2s, 4s ... capped at 30s.
+func loginDelay(failures int) time.Duration {
+	if failures <= loginDelayAfter {
+		return 0
+	}
+	n := failures - loginDelayAfter - 1
+	if n > 5 {
+		n = 5
+	}
+	d := time.Second << n
+	if d > 30*time.Second {
+		d = 30 * time.Second
+	}
+	return d
+}
+
+// ipBlocked reports whether ip has reached the failed attempt limit within
+// the lockout window.
+func ipBlocked(db *gorm.DB, ip string) bool {
+	var count int64
+	db.Model(&model.LoginAttempt{}).
+		Where("ip = ? AND success = ? AND created_at > ?", ip, false, time.Now().Add(-config.C.Auth.LockoutDuration)).
+		Count(&count)
+	return count >= int64(config.C.Auth.IPAttemptLimit)
+}
+
+// recordLoginAttempt stores the outcome of a password or second-factor login
+// attempt.
+func recordLoginAttempt(db *gorm.DB, c *gin.Context, username string, userID *uint, success bool) {
+	err := db.Create(&model.LoginAttempt{
+		Username: username,
+		UserID:   userID,
+		IP:       c.ClientIP(),
+		Success:  success,
+	}).Error
+	if err != nil {
+		utils.Logger.Error("Failed to record login attempt", "err", err)
+	}
+}
+
+// PruneLoginAttempts deletes login attempts recorded before the given time
+// and returns how many were deleted.
+func PruneLoginAttempts(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&model.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// loginThrottled returns a message when user may not attempt a password or
+// second-factor login right now, either because the account is locked or because the progressive
+// delay since the last failure has not yet passed.
+func loginThrottled(user *model.User) string {
+	if user.Locked() {
+		return "Too many failed sign-in attempts. Your account is temporarily locked; check your email to unlock it or try again later."
+	}
+	if user.LastFailedLoginAt != nil {
+		if wait := loginDelay(user.FailedLogins) - time.Since(*user.LastFailedLoginAt); wait > 0 {
+			return fmt.Sprintf("Too many failed sign-in attempts. Please wait %d seconds and try again.", int(wait.Seconds())+1)
+		}
+	}
+	return ""
+}
+
+// registerLoginFailure counts a failed password or code for user and locks the
+// account once the configured threshold is reached. The counter is
+// incremented in the database, so concurrent failures are all counted.
+func registerLoginFailure(db *gorm.DB, c *gin.Context, emailService *utils.EmailService, user *model.User) {
+	now := time.Now()
+	var stored model.User
+	err := db.Model(&stored).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_logins"}}}).
+		Where("id = ?", user.ID).
+		Updates(map[string]interface{}{
+			"failed_logins":        gorm.Expr("failed_logins + 1"),
+			"last_failed_login_at": now,
+		}).Error
+	if err != nil {
+		utils.Logger.Error("Failed to record login failure", "err", err, "user_id", user.ID)
+		return
+	}
+	user.FailedLogins = stored.FailedLogins
+	user.LastFailedLoginAt = &now
+
+	// Of concurrent failures reaching the threshold, only the one that
+	// starts the lockout reports it and sends the email
+	locked := false
+	if user.FailedLogins >= config.C.Auth.LockoutThreshold {
+		until := now.Add(config.C.Auth.LockoutDuration)
+		result := db.Model(&model.User{}).
+			Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", user.ID, now).
+			Update("locked_until", until)
+		if result.Error != nil {
+			utils.Logger.Error("Failed to lock account", "err", result.Error, "user_id", user.ID)
+		}
+		user.LockedUntil = &until
+		locked = result.RowsAffected == 1
+	}
+
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionLoginFailed,
+		TargetID: audit.ID(user.ID),
+		Metadata: map[string]interface{}{"failures": user.FailedLogins},
+	})
+	if !locked {
+		return
+	}
+
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionAccountLocked,
+		TargetID: audit.ID(user.ID),
+		Metadata: map[string]interface{}{"until": user.LockedUntil, "failures": user.FailedLogins},
+	})
+	if err := sendUnlockEmail(db, emailService, user); err != nil {
+		utils.Logger.Error("Failed to send unlock email", "err", err, "user_id", user.ID)
+	}
+}
+
+// clearLoginFailures resets the failure counters after a successful password
+// or code.
+func clearLoginFailures(db *gorm.DB, user *model.User) {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return
+	}
+	if err := unlockUser(db, user.ID); err != nil {
+		utils.Logger.Error("Failed to clear login failures", "err", err, "user_id", user.ID)
+	}
+}
+
+// sendUnlockEmail issues an unlock token for user and emails the link.
+func sendUnlockEmail(db *gorm.DB, emailService *utils.EmailService, user *model.User) error {
+	token, hash, err := utils.NewToken()
+	if err != nil {
+		return err
+	}
+	err = db.Create(&model.AccountUnlockToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(unlockTokenTTL),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if emailService == nil || user.Email == "" {
+		utils.Logger.Warn("Email service not configured; unlock link not sent", "user_id", user.ID)
+		return nil
+	}
+	link := config.C.URL("/unlock-account?token=" + url.QueryEscape(token))
+	return emailService.SendAccountLocked(user.Email, user.Name, link)
+}
+
+// UnlockAccount consumes an emailed unlock token and clears the lockout.
+func UnlockAccount(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.Redirect(http.StatusFound, "/login?error=invalid_unlock")
+			return
+		}
+
+		var record model.AccountUnlockToken
+		err := db.Where("token_hash = ? AND expires_at > ?", utils.SignToken(token), time.Now()).First(&record).Error
+		if err != nil {
+			renderLoginMessage(c, http.StatusOK, "Error", "This unlock link is invalid or has expired.")
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", record.UserID).Delete(&model.AccountUnlockToken{}).Error; err != nil {
+				return err
+			}
+			return unlockUser(tx, record.UserID)
+		})
+		if err != nil {
+			utils.Logger.Error("Failed to unlock account", "err", err, "user_id", record.UserID)
+			renderLoginMessage(c, http.StatusInternalServerError, "Error", "Failed to unlock your account. Please try again.")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionAccountUnlocked,
+			TargetID: audit.ID(record.UserID),
+			Metadata: map[string]interface{}{"via": "email"},
+		})
+		renderLoginMessage(c, http.StatusOK, "Message", "Your account has been unlocked. Please sign in.")
+	}
+}
+
+// unlockUser clears the lockout and failure counters for userID.
+func unlockUser(db *gorm.DB, userID uint) error {
+	return db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_logins":        0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}).Error
+}
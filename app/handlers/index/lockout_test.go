@@ -0,0 +1,60 @@
+package index
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dariubs/scaffold/app/model"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{loginDelayAfter, 0},
+		{loginDelayAfter + 1, time.Second},
+		{loginDelayAfter + 2, 2 * time.Second},
+		{loginDelayAfter + 3, 4 * time.Second},
+		{loginDelayAfter + 6, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	until := now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		user model.User
+		want string // Substring of the message; empty when not throttled
+	}{
+		{"no failures", model.User{}, ""},
+		{"below delay threshold", model.User{FailedLogins: loginDelayAfter, LastFailedLoginAt: ago(0)}, ""},
+		{"waiting", model.User{FailedLogins: loginDelayAfter + 3, LastFailedLoginAt: ago(time.Second)}, "wait 3 seconds"},
+		{"delay passed", model.User{FailedLogins: loginDelayAfter + 3, LastFailedLoginAt: ago(5 * time.Second)}, ""},
+		{"locked", model.User{FailedLogins: 10, LockedUntil: &until}, "temporarily locked"},
+		{"lock expired", model.User{FailedLogins: 10, LockedUntil: ago(time.Second), LastFailedLoginAt: ago(time.Minute)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := loginThrottled(&tt.user)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("loginThrottled() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
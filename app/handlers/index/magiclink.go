@@ -0,0 +1,142 @@
+package index
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// magicLinkInterval is the minimum time between sign-in links for the same
+// address.
+const magicLinkInterval = time.Minute
+
+func renderLoginMessage(c *gin.Context, status int, key, msg string) {
+	data := gin.H{"Title": "Login", key: msg}
+	for k, v := range loginFormData() {
+		data[k] = v
+	}
+	c.HTML(status, "login.html", data)
+}
+
+// RequestMagicLink emails a one-time sign-in link. The response is the same
+// whether or not an account exists for the address.
+func RequestMagicLink(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !settings.Bool(settings.LoginMagicLink) {
+			c.Redirect(http.StatusFound, "/login?error=magic_link_disabled")
+			return
+		}
+		email := strings.ToLower(strings.TrimSpace(c.PostForm("email")))
+		if !utils.ValidateEmail(email) {
+			renderLoginMessage(c, http.StatusOK, "Error", "Please enter a valid email address")
+			return
+		}
+
+		if err := sendMagicLink(db, emailService, email); err != nil {
+			utils.Logger.Error("Failed to send magic link", "err", err)
+		}
+		renderLoginMessage(c, http.StatusOK, "Message", "Check your email for a sign-in link.")
+	}
+}
+
+// sendMagicLink issues a sign-in token for email and sends the link. Repeat
+// requests within magicLinkInterval are silently dropped.
+func sendMagicLink(db *gorm.DB, emailService *utils.EmailService, email string) error {
+	var last model.MagicLinkToken
+	if db.Where("email = ?", email).Order("created_at DESC").First(&last).Error == nil &&
+		time.Since(last.CreatedAt) < magicLinkInterval {
+		return nil
+	}
+
+	token, hash, err := utils.NewToken()
+	if err != nil {
+		return err
+	}
+	err = db.Create(&model.MagicLinkToken{
+		Email:     email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(config.C.Auth.MagicLinkTTL),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if emailService == nil {
+		utils.Logger.Warn("Email service not configured; magic link not sent")
+		return nil
+	}
+	link := config.C.URL("/login/magic?token=" + url.QueryEscape(token))
+	return emailService.SendMagicLink(email, link)
+}
+
+// MagicLinkLogin consumes a sign-in link and logs the user in, creating a
+// password-less account on first use.
+func MagicLinkLogin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !settings.Bool(settings.LoginMagicLink) {
+			c.Redirect(http.StatusFound, "/login?error=magic_link_disabled")
+			return
+		}
+
+		// Consume the token atomically so a link cannot be used twice
+		now := time.Now()
+		var record model.MagicLinkToken
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.SignToken(c.Query("token")), now).
+				First(&record).Error; err != nil {
+				return err
+			}
+			result := tx.Model(&record).Where("used_at IS NULL").Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return nil
+		})
+		if err != nil {
+			renderLoginMessage(c, http.StatusBadRequest, "Error", "This sign-in link is invalid or has expired.")
+			return
+		}
+
+		// Sign in the account with this email, creating one on first use
+		var user model.User
+		err = db.Where("LOWER(email) = ?", record.Email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			user = model.User{
+				Username:        record.Email, // Use email as username, as for OAuth users
+				Email:           record.Email,
+				Password:        "",
+				LoginMethod:     "email",
+				EmailVerifiedAt: &now,
+			}
+			var taken int64
+			db.Model(&model.User{}).Where("username = ?", user.Username).Count(&taken)
+			if taken > 0 {
+				user.Username = "email_" + strconv.FormatUint(uint64(record.ID), 10)
+			}
+			err = db.Create(&user).Error
+		} else if err == nil && user.EmailVerifiedAt == nil {
+			// Following the emailed link proves ownership of the address
+			user.EmailVerifiedAt = &now
+			err = db.Model(&user).Update("email_verified_at", now).Error
+		}
+		if err != nil {
+			renderLoginMessage(c, http.StatusInternalServerError, "Error", "Failed to create user account")
+			return
+		}
+
+		finishLogin(c, db, &user, "magic_link")
+	}
+}
@@ -0,0 +1,362 @@
+package index
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/tenant"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+// organizationErrors maps the error codes that organization actions redirect
+// with to the message shown on the organizations page.
+var organizationErrors = map[string]string{
+	"name":            "Organization name must be between 1 and 100 characters.",
+	"create":          "Failed to create the organization.",
+	"switch":          "You are not a member of that organization.",
+	"email":           "Please enter a valid email address.",
+	"role":            "Unknown organization role.",
+	"already_member":  "That user is already a member.",
+	"invite":          "Failed to send the invitation.",
+	"forbidden_role":  "You cannot grant a role higher than your own.",
+	"last_owner":      "An organization must keep at least one owner.",
+	"remove":          "Failed to remove the member.",
+	"invitation":      "This invitation is invalid or has expired.",
+	"invitation_user": "This invitation was sent to a different email address.",
+	"accept":          "Failed to accept the invitation.",
+}
+
+// organizationMessages maps the success codes that organization actions
+// redirect with to the message shown on the organizations page.
+var organizationMessages = map[string]string{
+	"created":  "Organization created.",
+	"switched": "Switched organization.",
+	"invited":  "Invitation sent.",
+	"revoked":  "Invitation revoked.",
+	"removed":  "Member removed.",
+	"joined":   "You joined the organization.",
+}
+
+// Organizations lists the user's organizations with a switcher and the
+// members and pending invitations of the current organization.
+func Organizations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+
+		memberships, _ := tenant.Memberships(db, user.ID)
+		data := gin.H{
+			"Title":       "Organizations",
+			"User":        user,
+			"Memberships": memberships,
+			"Roles":       tenant.Roles,
+			"CSRFToken":   c.GetString("csrf_token"),
+		}
+		addImpersonator(c, db, data)
+		if org, membership, ok := tenant.Current(c); ok {
+			var members []model.Membership
+			tenant.DB(c, db).Preload("User").Order("created_at").Find(&members)
+			data["Organization"] = org
+			data["Membership"] = membership
+			data["Members"] = members
+			data["CanManage"] = tenant.AtLeast(membership.Role, tenant.RoleAdmin)
+
+			var sent []model.OrganizationInvitation
+			tenant.DB(c, db).Where("accepted_at IS NULL AND declined_at IS NULL AND expires_at > ?", time.Now()).
+				Order("created_at DESC").Find(&sent)
+			data["SentInvitations"] = sent
+		}
+		if msg, ok := organizationErrors[c.Query("error")]; ok {
+			data["Error"] = msg
+		}
+		if msg, ok := organizationMessages[c.Query("message")]; ok {
+			data["Message"] = msg
+		}
+		c.HTML(http.StatusOK, "organizations.html", data)
+	}
+}
+
+// CreateOrganization creates an organization owned by the user and switches
+// to it.
+func CreateOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" || len(name) > 100 {
+			c.Redirect(http.StatusFound, "/orgs?error=name")
+			return
+		}
+
+		org, err := tenant.Create(db, name, user.ID)
+		if err != nil {
+			utils.Logger.Error("Failed to create organization", "err", err, "user_id", user.ID)
+			c.Redirect(http.StatusFound, "/orgs?error=create")
+			return
+		}
+
+		session := sessions.Default(c)
+		session.Set("org_id", org.ID)
+		session.Save()
+		c.Redirect(http.StatusFound, "/orgs?message=created")
+	}
+}
+
+// SwitchOrganization makes the posted organization the current one.
+func SwitchOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		orgID, _ := strconv.ParseUint(c.PostForm("organization_id"), 10, 64)
+		if _, err := tenant.Membership(db, uint(orgID), user.ID); err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=switch")
+			return
+		}
+
+		session := sessions.Default(c)
+		session.Set("org_id", uint(orgID))
+		session.Save()
+		c.Redirect(http.StatusFound, "/orgs?message=switched")
+	}
+}
+
+// InviteMember emails an invitation to join the current organization.
+func InviteMember(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		org, membership, _ := tenant.Current(c)
+
+		email := strings.TrimSpace(c.PostForm("email"))
+		role := c.PostForm("role")
+		if !utils.ValidateEmail(email) {
+			c.Redirect(http.StatusFound, "/orgs?error=email")
+			return
+		}
+		if !tenant.ValidRole(role) {
+			c.Redirect(http.StatusFound, "/orgs?error=role")
+			return
+		}
+		if !tenant.AtLeast(membership.Role, role) {
+			c.Redirect(http.StatusFound, "/orgs?error=forbidden_role")
+			return
+		}
+
+		var existing int64
+		tenant.DB(c, db).Model(&model.Membership{}).
+			Joins("JOIN users ON users.id = memberships.user_id").
+			Where("LOWER(users.email) = LOWER(?)", email).Count(&existing)
+		if existing > 0 {
+			c.Redirect(http.StatusFound, "/orgs?error=already_member")
+			return
+		}
+
+		token, hash, err := utils.NewToken()
+		if err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=invite")
+			return
+		}
+		invitation := model.OrganizationInvitation{
+			OrganizationID: org.ID,
+			Email:          email,
+			Role:           role,
+			InvitedByID:    user.ID,
+			TokenHash:      hash,
+			ExpiresAt:      time.Now().Add(invitationTTL),
+		}
+		if err := db.Create(&invitation).Error; err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=invite")
+			return
+		}
+
+		link := config.C.URL("/invitations?token=" + url.QueryEscape(token))
+		if emailService == nil {
+			utils.Logger.Warn("Email service not configured; invitation not sent", "organization_id", org.ID, "invitation_id", invitation.ID)
+		} else if err := emailService.SendOrganizationInvitation(email, org.Name, user.Name, link); err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=invite")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionMemberInvited,
+			ActorID:  audit.ID(user.ID),
+			Metadata: map[string]interface{}{"organization_id": org.ID, "email": email, "role": role},
+		})
+		c.Redirect(http.StatusFound, "/orgs?message=invited")
+	}
+}
+
+// RevokeInvitation cancels a pending invitation to the current organization.
+func RevokeInvitation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant.DB(c, db).Where("id = ? AND accepted_at IS NULL", c.Param("id")).
+			Delete(&model.OrganizationInvitation{})
+		c.Redirect(http.StatusFound, "/orgs?message=revoked")
+	}
+}
+
+// RemoveMember removes a member from the current organization. Only owners
+// can remove owners, and the last owner cannot be removed.
+func RemoveMember(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		org, membership, _ := tenant.Current(c)
+
+		var target model.Membership
+		if err := tenant.DB(c, db).Where("id = ?", c.Param("id")).First(&target).Error; err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=remove")
+			return
+		}
+		if !tenant.AtLeast(membership.Role, target.Role) {
+			c.Redirect(http.StatusFound, "/orgs?error=forbidden_role")
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if target.Role == tenant.RoleOwner {
+				var owners int64
+				tx.Model(&model.Membership{}).Scopes(tenant.Scope(org.ID)).
+					Where("role = ?", tenant.RoleOwner).Count(&owners)
+				if owners <= 1 {
+					return errLastOwner
+				}
+			}
+			return tx.Delete(&target).Error
+		})
+		if errors.Is(err, errLastOwner) {
+			c.Redirect(http.StatusFound, "/orgs?error=last_owner")
+			return
+		}
+		if err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=remove")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionMemberRemoved,
+			ActorID:  audit.ID(user.ID),
+			TargetID: audit.ID(target.UserID),
+			Metadata: map[string]interface{}{"organization_id": org.ID},
+		})
+		c.Redirect(http.StatusFound, "/orgs?message=removed")
+	}
+}
+
+var errLastOwner = errors.New("organization must keep an owner")
+
+// findInvitation looks up a pending invitation by its emailed token.
+func findInvitation(db *gorm.DB, token string) (*model.OrganizationInvitation, bool) {
+	if token == "" {
+		return nil, false
+	}
+	var invitation model.OrganizationInvitation
+	err := db.Preload("Organization").Where("token_hash = ?", utils.SignToken(token)).First(&invitation).Error
+	if err != nil || !invitation.Pending() {
+		return nil, false
+	}
+	return &invitation, true
+}
+
+// Invitation shows an emailed invitation with accept and decline buttons.
+func Invitation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		data := gin.H{"Title": "Invitation", "Token": token, "CSRFToken": c.GetString("csrf_token")}
+
+		invitation, ok := findInvitation(db, token)
+		if !ok {
+			data["Error"] = organizationErrors["invitation"]
+			c.HTML(http.StatusOK, "invitation.html", data)
+			return
+		}
+		data["Invitation"] = invitation
+		if userID, _ := sessions.Default(c).Get("user_id").(uint); userID != 0 {
+			var user model.User
+			if db.First(&user, userID).Error == nil {
+				data["User"] = user
+				addImpersonator(c, db, data)
+			}
+		}
+		c.HTML(http.StatusOK, "invitation.html", data)
+	}
+}
+
+// AcceptInvitation adds the signed-in user to the invited organization and
+// switches to it. The user's email must match the invited address.
+func AcceptInvitation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		invitation, ok := findInvitation(db, c.PostForm("token"))
+		if !ok {
+			c.Redirect(http.StatusFound, "/orgs?error=invitation")
+			return
+		}
+		if !strings.EqualFold(invitation.Email, user.Email) {
+			c.Redirect(http.StatusFound, "/orgs?error=invitation_user")
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			if err := tx.Model(invitation).Update("accepted_at", now).Error; err != nil {
+				return err
+			}
+			var existing int64
+			tx.Model(&model.Membership{}).Scopes(tenant.Scope(invitation.OrganizationID)).
+				Where("user_id = ?", user.ID).Count(&existing)
+			if existing > 0 {
+				return nil
+			}
+			return tx.Create(&model.Membership{
+				OrganizationID: invitation.OrganizationID,
+				UserID:         user.ID,
+				Role:           invitation.Role,
+			}).Error
+		})
+		if err != nil {
+			c.Redirect(http.StatusFound, "/orgs?error=accept")
+			return
+		}
+
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionMemberJoined,
+			ActorID:  audit.ID(user.ID),
+			TargetID: audit.ID(user.ID),
+			Metadata: map[string]interface{}{"organization_id": invitation.OrganizationID, "role": invitation.Role},
+		})
+
+		session := sessions.Default(c)
+		session.Set("org_id", invitation.OrganizationID)
+		session.Save()
+		c.Redirect(http.StatusFound, "/orgs?message=joined")
+	}
+}
+
+// DeclineInvitation marks an invitation declined. Holding the emailed token
+// is enough, so it works without signing in.
+func DeclineInvitation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitation, ok := findInvitation(db, c.PostForm("token"))
+		if !ok {
+			c.HTML(http.StatusOK, "invitation.html", gin.H{
+				"Title": "Invitation",
+				"Error": organizationErrors["invitation"],
+			})
+			return
+		}
+
+		db.Model(invitation).Update("declined_at", time.Now())
+		c.HTML(http.StatusOK, "invitation.html", gin.H{
+			"Title":   "Invitation",
+			"Message": "You declined the invitation to join " + invitation.Organization.Name + ".",
+		})
+	}
+}
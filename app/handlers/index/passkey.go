@@ -0,0 +1,368 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+// passkeyUser adapts a model.User and its stored credentials to the
+// webauthn.User interface.
+type passkeyUser struct {
+	user        model.User
+	credentials []model.PasskeyCredential
+}
+
+// WebAuthnID is the user handle stored on the authenticator; it is the
+// decimal user ID so discoverable logins can look the account up.
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(u.user.ID), 10))
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		creds = append(creds, toWebAuthnCredential(c))
+	}
+	return creds
+}
+
+func toWebAuthnCredential(c model.PasskeyCredential) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range strings.Split(c.Transports, ",") {
+		if t != "" {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+	}
+	return webauthn.Credential{
+		ID:              c.CredentialID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.Flags)),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+// loadPasskeyUser loads user together with their registered credentials.
+func loadPasskeyUser(db *gorm.DB, user model.User) (*passkeyUser, error) {
+	var creds []model.PasskeyCredential
+	if err := db.Where("user_id = ?", user.ID).Find(&creds).Error; err != nil {
+		return nil, err
+	}
+	return &passkeyUser{user: user, credentials: creds}, nil
+}
+
+// saveCeremony stores WebAuthn session data in the cookie session under key.
+func saveCeremony(c *gin.Context, key string, data *webauthn.SessionData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	session := sessions.Default(c)
+	session.Set(key, string(raw))
+	return session.Save()
+}
+
+// takeCeremony removes and returns the WebAuthn session data stored under key.
+func takeCeremony(c *gin.Context, key string) (*webauthn.SessionData, bool) {
+	session := sessions.Default(c)
+	raw, _ := session.Get(key).(string)
+	session.Delete(key)
+	session.Save()
+	if raw == "" {
+		return nil, false
+	}
+	var data webauthn.SessionData
+	if json.Unmarshal([]byte(raw), &data) != nil {
+		return nil, false
+	}
+	return &data, true
+}
+
+// newPasskeyCredential returns the record storing a newly registered
+// credential; toWebAuthnCredential converts it back.
+func newPasskeyCredential(userID uint, name string, cred *webauthn.Credential) model.PasskeyCredential {
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	return model.PasskeyCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Flags:           uint8(cred.Flags.ProtocolValue()),
+	}
+}
+
+// recordPasskeyUse updates the sign counter and last-used time after a
+// successful assertion.
+func recordPasskeyUse(db *gorm.DB, cred *webauthn.Credential) {
+	now := time.Now()
+	db.Model(&model.PasskeyCredential{}).Where("credential_id = ?", cred.ID).Updates(map[string]interface{}{
+		"sign_count":   cred.Authenticator.SignCount,
+		"flags":        uint8(cred.Flags.ProtocolValue()),
+		"last_used_at": now,
+	})
+	if cred.Authenticator.CloneWarning {
+		utils.Logger.Warn("Passkey sign counter did not increase; authenticator may be cloned")
+	}
+}
+
+// BeginPasskeyRegistration returns credential creation options for the
+// logged-in user.
+func BeginPasskeyRegistration(db *gorm.DB, wa *webauthn.WebAuthn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		pu, err := loadPasskeyUser(db, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load passkeys"})
+			return
+		}
+
+		options, data, err := wa.BeginRegistration(pu,
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+			webauthn.WithExclusions(webauthn.Credentials(pu.WebAuthnCredentials()).CredentialDescriptors()),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+			return
+		}
+		if err := saveCeremony(c, "webauthn_registration", data); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+			return
+		}
+		c.JSON(http.StatusOK, options)
+	}
+}
+
+// FinishPasskeyRegistration verifies the attestation response and stores the
+// new credential.
+func FinishPasskeyRegistration(db *gorm.DB, wa *webauthn.WebAuthn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		data, ok := takeCeremony(c, "webauthn_registration")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No registration in progress"})
+			return
+		}
+		pu, err := loadPasskeyUser(db, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load passkeys"})
+			return
+		}
+
+		cred, err := wa.FinishRegistration(pu, *data, c.Request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration failed"})
+			return
+		}
+
+		name := utils.SanitizeString(c.Query("name"), 64)
+		if name == "" {
+			name = "Passkey"
+		}
+		record := newPasskeyCredential(user.ID, name, cred)
+		if err := db.Create(&record).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Passkey registered", "id": record.ID, "name": record.Name})
+	}
+}
+
+// DeletePasskey removes one of the logged-in user's passkeys.
+func DeletePasskey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		if loginMethodCount(db, &user) <= 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your only way to sign in"})
+			return
+		}
+		result := db.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&model.PasskeyCredential{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+	}
+}
+
+// passkeyLoginOptions require the authenticator to verify the user with a PIN
+// or biometric. Passkey logins skip the TOTP prompt, which is only safe when
+// the passkey itself covers both factors.
+var passkeyLoginOptions = []webauthn.LoginOption{
+	webauthn.WithUserVerification(protocol.VerificationRequired),
+}
+
+// errUserNotVerified is returned for assertions without the user verified
+// flag.
+var errUserNotVerified = errors.New("passkey login: user not verified")
+
+// verifyPasskeyLogin checks the discoverable login assertion in r against
+// data and returns the user find loads for the assertion's user handle. The
+// assertion must be user-verified.
+func verifyPasskeyLogin(wa *webauthn.WebAuthn, data webauthn.SessionData, r *http.Request, find func(id uint) (*passkeyUser, error)) (*passkeyUser, *webauthn.Credential, error) {
+	var found *passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.ParseUint(string(userHandle), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		found, err = find(uint(id))
+		return found, err
+	}
+	_, cred, err := wa.FinishPasskeyLogin(handler, data, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if found == nil || !cred.Flags.UserVerified {
+		return nil, nil, errUserNotVerified
+	}
+	return found, cred, nil
+}
+
+// BeginPasskeyLogin returns assertion options for a discoverable (usernameless)
+// passkey login.
+func BeginPasskeyLogin(wa *webauthn.WebAuthn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, data, err := wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+			return
+		}
+		if err := saveCeremony(c, "webauthn_login", data); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+			return
+		}
+		c.JSON(http.StatusOK, options)
+	}
+}
+
+// FinishPasskeyLogin verifies the assertion and logs the owning user in. The
+// passkey must have verified the user, so it already covers two factors and
+// no TOTP prompt follows.
+func FinishPasskeyLogin(db *gorm.DB, wa *webauthn.WebAuthn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, ok := takeCeremony(c, "webauthn_login")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No login in progress"})
+			return
+		}
+
+		found, cred, err := verifyPasskeyLogin(wa, *data, c.Request, func(id uint) (*passkeyUser, error) {
+			var user model.User
+			if err := db.First(&user, id).Error; err != nil {
+				return nil, err
+			}
+			return loadPasskeyUser(db, user)
+		})
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey login failed"})
+			return
+		}
+		recordPasskeyUse(db, cred)
+
+		if refuseSuspended(c, db, &found.user, "passkey") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This account is suspended"})
+			return
+		}
+		logIn(c, db, &found.user, "passkey", "")
+		c.JSON(http.StatusOK, gin.H{"redirect": "/"})
+	}
+}
+
+// BeginPasskeySecondFactor returns assertion options restricted to the
+// credentials of the user waiting at the two-factor prompt.
+func BeginPasskeySecondFactor(db *gorm.DB, wa *webauthn.WebAuthn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := pendingTwoFactorUser(c, db)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No login in progress"})
+			return
+		}
+		pu, err := loadPasskeyUser(db, *user)
+		if err != nil || len(pu.credentials) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No passkeys registered"})
+			return
+		}
+
+		options, data, err := wa.BeginLogin(pu)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey verification"})
+			return
+		}
+		if err := saveCeremony(c, "webauthn_2fa", data); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+			return
+		}
+		c.JSON(http.StatusOK, options)
+	}
+}
+
+// FinishPasskeySecondFactor completes a pending login with a passkey instead
+// of a TOTP code.
+func FinishPasskeySecondFactor(db *gorm.DB, wa *webauthn.WebAuthn) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := pendingTwoFactorUser(c, db)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No login in progress"})
+			return
+		}
+		data, ok := takeCeremony(c, "webauthn_2fa")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No verification in progress"})
+			return
+		}
+		pu, err := loadPasskeyUser(db, *user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load passkeys"})
+			return
+		}
+
+		cred, err := wa.FinishLogin(pu, *data, c.Request)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey verification failed"})
+			return
+		}
+		recordPasskeyUse(db, cred)
+
+		logIn(c, db, user, pendingLoginMethod(c), "passkey")
+		c.JSON(http.StatusOK, gin.H{"redirect": "/"})
+	}
+}
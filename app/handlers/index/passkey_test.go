@@ -0,0 +1,293 @@
+package index
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// softAuthenticator is a software passkey holding one P-256 credential.
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id}
+}
+
+var b64 = base64.RawURLEncoding
+
+// cborBytes encodes b as a CBOR byte string.
+func cborBytes(b []byte) []byte {
+	switch {
+	case len(b) < 24:
+		return append([]byte{0x40 | byte(len(b))}, b...)
+	case len(b) < 256:
+		return append([]byte{0x58, byte(len(b))}, b...)
+	default:
+		return append([]byte{0x59, byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+}
+
+// cborText encodes s as a short CBOR text string.
+func cborText(s string) []byte {
+	return append([]byte{0x60 | byte(len(s))}, s...)
+}
+
+// coseKey returns the public key as a COSE_Key map: kty EC2, alg ES256,
+// curve P-256.
+func (a *softAuthenticator) coseKey() []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	out := []byte{0xA5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21}
+	out = append(out, cborBytes(x)...)
+	out = append(out, 0x22)
+	return append(out, cborBytes(y)...)
+}
+
+func (a *softAuthenticator) authData(flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	out := append(rpIDHash[:], flags)
+	out = binary.BigEndian.AppendUint32(out, a.signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...) // AAGUID
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.id)))
+		out = append(out, a.id...)
+		out = append(out, a.coseKey()...)
+	}
+	return out
+}
+
+func clientData(typ string, challenge protocol.URLEncodedBase64) []byte {
+	b, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	return b
+}
+
+func jsonRequest(t *testing.T, body interface{}) *http.Request {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+}
+
+// register answers a registration ceremony with a "none" attestation.
+func (a *softAuthenticator) register(t *testing.T, options *protocol.CredentialCreation) *http.Request {
+	t.Helper()
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+	attestation := []byte{0xA3}
+	attestation = append(attestation, cborText("fmt")...)
+	attestation = append(attestation, cborText("none")...)
+	attestation = append(attestation, cborText("attStmt")...)
+	attestation = append(attestation, 0xA0)
+	attestation = append(attestation, cborText("authData")...)
+	attestation = append(attestation, cborBytes(a.authData(flagUserPresent|flagUserVerified|flagAttested, true))...)
+	return jsonRequest(t, map[string]interface{}{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData("webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+}
+
+// assert answers a login ceremony, signing with the given authenticator
+// data flags.
+func (a *softAuthenticator) assert(t *testing.T, options *protocol.CredentialAssertion, flags byte) *http.Request {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(flags, false)
+	cd := clientData("webauthn.get", options.Response.Challenge)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jsonRequest(t, map[string]interface{}{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(cd),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(sig),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	})
+}
+
+// setupPasskey registers a software authenticator for a user and returns
+// both, with the credential stored the way FinishPasskeyRegistration stores
+// it.
+func setupPasskey(t *testing.T, wa *webauthn.WebAuthn) (*softAuthenticator, *passkeyUser) {
+	t.Helper()
+	pu := &passkeyUser{user: model.User{Model: gorm.Model{ID: 42}, Username: "alice", Email: "alice@example.com"}}
+	auth := newSoftAuthenticator(t)
+
+	options, data, err := wa.BeginRegistration(pu,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := wa.FinishRegistration(pu, *data, auth.register(t, options))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	pu.credentials = []model.PasskeyCredential{newPasskeyCredential(pu.user.ID, "Test key", cred)}
+	return auth, pu
+}
+
+func testWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "Scaffold",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wa
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	wa := testWebAuthn(t)
+	auth, pu := setupPasskey(t, wa)
+	find := func(id uint) (*passkeyUser, error) {
+		if id != pu.user.ID {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return pu, nil
+	}
+
+	tests := []struct {
+		name    string
+		flags   byte
+		tamper  func(*protocol.CredentialAssertion)
+		wantErr bool
+	}{
+		{name: "user verified", flags: flagUserPresent | flagUserVerified},
+		{name: "user present only", flags: flagUserPresent, wantErr: true},
+		{name: "wrong challenge", flags: flagUserPresent | flagUserVerified, wantErr: true,
+			tamper: func(o *protocol.CredentialAssertion) {
+				o.Response.Challenge = protocol.URLEncodedBase64("other challenge")
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, data, err := wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if options.Response.UserVerification != protocol.VerificationRequired {
+				t.Errorf("user verification = %q, want required", options.Response.UserVerification)
+			}
+			if tt.tamper != nil {
+				tt.tamper(options)
+			}
+			user, cred, err := verifyPasskeyLogin(wa, *data, auth.assert(t, options, tt.flags), find)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("login succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("login failed: %v", err)
+			}
+			if user.user.ID != pu.user.ID {
+				t.Errorf("logged in as user %d, want %d", user.user.ID, pu.user.ID)
+			}
+			if cred.Authenticator.SignCount != auth.signCount {
+				t.Errorf("sign count = %d, want %d", cred.Authenticator.SignCount, auth.signCount)
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginRejectsOtherKey(t *testing.T) {
+	wa := testWebAuthn(t)
+	auth, pu := setupPasskey(t, wa)
+	// An attacker's key claiming the same credential ID and user handle
+	forged := newSoftAuthenticator(t)
+	forged.id, forged.userHandle = auth.id, auth.userHandle
+
+	options, data, err := wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = verifyPasskeyLogin(wa, *data, forged.assert(t, options, flagUserPresent|flagUserVerified),
+		func(id uint) (*passkeyUser, error) { return pu, nil })
+	if err == nil {
+		t.Fatal("login with a forged signature succeeded")
+	}
+}
+
+func TestPasskeySecondFactor(t *testing.T) {
+	// As a second factor after a password, user presence is enough
+	wa := testWebAuthn(t)
+	auth, pu := setupPasskey(t, wa)
+	options, data, err := wa.BeginLogin(pu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wa.FinishLogin(pu, *data, auth.assert(t, options, flagUserPresent)); err != nil {
+		t.Fatalf("second factor failed: %v", err)
+	}
+}
+
+func TestVerifyPasskeyLoginUnknownUser(t *testing.T) {
+	wa := testWebAuthn(t)
+	auth, _ := setupPasskey(t, wa)
+	options, data, err := wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notFound := errors.New("not found")
+	_, _, err = verifyPasskeyLogin(wa, *data, auth.assert(t, options, flagUserPresent|flagUserVerified),
+		func(id uint) (*passkeyUser, error) { return nil, notFound })
+	if err == nil {
+		t.Fatal("login for an unknown user succeeded")
+	}
+}
@@ -0,0 +1,189 @@
+package index
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// passwordResetInterval is the minimum time between reset emails for the same
+// account.
+const passwordResetInterval = time.Minute
+
+// forgotPasswordSent is shown after every reset request so the response does
+// not reveal whether an account exists.
+const forgotPasswordSent = "If an account exists for that email, a password reset link has been sent."
+
+func ForgotPasswordForm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, "forgot_password.html", gin.H{"Title": "Forgot password"})
+	}
+}
+
+func ForgotPassword(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := strings.TrimSpace(c.PostForm("email"))
+
+		// The email is sent in the background so that the response takes as
+		// long whether or not the address is registered
+		var user model.User
+		if email != "" && db.Where("email = ?", email).First(&user).Error == nil {
+			go func() {
+				if err := SendPasswordReset(db, emailService, &user); err != nil {
+					utils.Logger.Error("Failed to send password reset", "err", err, "user_id", user.ID)
+				}
+			}()
+		}
+
+		c.HTML(http.StatusOK, "forgot_password.html", gin.H{
+			"Title":   "Forgot password",
+			"Message": forgotPasswordSent,
+		})
+	}
+}
+
+// SendPasswordReset issues a reset token for user and emails the link. Repeat
+// requests within passwordResetInterval are silently dropped.
+func SendPasswordReset(db *gorm.DB, emailService *utils.EmailService, user *model.User) error {
+	var last model.PasswordResetToken
+	if db.Where("user_id = ?", user.ID).Order("created_at DESC").First(&last).Error == nil &&
+		time.Since(last.CreatedAt) < passwordResetInterval {
+		return nil
+	}
+
+	token, hash, err := utils.NewToken()
+	if err != nil {
+		return err
+	}
+	err = db.Create(&model.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(config.C.Auth.PasswordResetTTL),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if emailService == nil {
+		utils.Logger.Warn("Email service not configured; password reset link not sent", "user_id", user.ID)
+		return nil
+	}
+	link := config.C.URL("/reset-password?token=" + url.QueryEscape(token))
+	return emailService.SendPasswordReset(user.Email, user.Name, link)
+}
+
+// findPasswordResetToken returns the unused, unexpired token record for token.
+func findPasswordResetToken(db *gorm.DB, token string) (*model.PasswordResetToken, bool) {
+	if token == "" {
+		return nil, false
+	}
+	var record model.PasswordResetToken
+	if err := db.Where("token_hash = ? AND used_at IS NULL", utils.SignToken(token)).First(&record).Error; err != nil {
+		return nil, false
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, false
+	}
+	return &record, true
+}
+
+func ResetPasswordForm(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		data := gin.H{"Title": "Reset password", "Token": token}
+		if _, ok := findPasswordResetToken(db, token); !ok {
+			data["Invalid"] = true
+		}
+		c.HTML(http.StatusOK, "reset_password.html", data)
+	}
+}
+
+func ResetPassword(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.PostForm("token")
+		password := c.PostForm("password")
+
+		renderError := func(msg string) {
+			c.HTML(http.StatusOK, "reset_password.html", gin.H{
+				"Title": "Reset password",
+				"Token": token,
+				"Error": msg,
+			})
+		}
+
+		record, ok := findPasswordResetToken(db, token)
+		if !ok {
+			c.HTML(http.StatusBadRequest, "reset_password.html", gin.H{
+				"Title":   "Reset password",
+				"Invalid": true,
+			})
+			return
+		}
+
+		if password != c.PostForm("password_confirm") {
+			renderError("Passwords do not match")
+			return
+		}
+		if !utils.ValidatePasswordStrength(password) {
+			renderError("Password must be at least 8 characters and include upper and lower case letters and a number")
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			renderError("Error resetting password")
+			return
+		}
+
+		// Revoke every existing session for the user; PasswordChangedAt also
+		// rejects any session that is still in flight
+		now := time.Now()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var user model.User
+			if err := tx.First(&user, record.UserID).Error; err != nil {
+				return err
+			}
+			user.Password = string(hashedPassword)
+			user.PasswordChangedAt = &now
+			// Following the emailed link proves ownership of the address
+			if user.EmailVerifiedAt == nil {
+				user.EmailVerifiedAt = &now
+			}
+			// A new password lifts any brute-force lockout
+			user.FailedLogins = 0
+			user.LastFailedLoginAt = nil
+			user.LockedUntil = nil
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&model.PasswordResetToken{}).
+				Where("user_id = ? AND used_at IS NULL", record.UserID).
+				Update("used_at", now).Error; err != nil {
+				return err
+			}
+			return sessionstore.RevokeUser(tx, record.UserID)
+		})
+		if err != nil {
+			renderError("Error resetting password")
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionPasswordChanged,
+			ActorID:  audit.ID(record.UserID),
+			TargetID: audit.ID(record.UserID),
+			Metadata: map[string]interface{}{"via": "reset_link"},
+		})
+
+		c.Redirect(http.StatusFound, "/login?reset=1")
+	}
+}
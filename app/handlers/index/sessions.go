@@ -0,0 +1,38 @@
+package index
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RevokeSession signs out one of the logged-in user's other sessions.
+func RevokeSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		id := c.Param("id")
+		if id == sessions.Default(c).ID() {
+			c.Redirect(http.StatusFound, "/logout")
+			return
+		}
+		db.Where("id = ? AND user_id = ?", id, user.ID).Delete(&model.Session{})
+		c.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+// RevokeOtherSessions signs out every session of the logged-in user except
+// the current one.
+func RevokeOtherSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		if err := sessionstore.RevokeUser(db, user.ID, sessions.Default(c).ID()); err != nil {
+			c.Redirect(http.StatusFound, "/profile?error=sessions")
+			return
+		}
+		c.Redirect(http.StatusFound, "/profile")
+	}
+}
@@ -0,0 +1,33 @@
+package index
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// refuseSuspended records a login refused because user is suspended. It
+// reports whether the login was refused.
+func refuseSuspended(c *gin.Context, db *gorm.DB, user *model.User, method string) bool {
+	if !user.Suspended() {
+		return false
+	}
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionLoginFailed,
+		TargetID: audit.ID(user.ID),
+		Metadata: map[string]interface{}{"method": method, "reason": "suspended"},
+	})
+	return true
+}
+
+// renderSuspended shows the suspension page, which explains why user cannot
+// sign in and until when.
+func renderSuspended(c *gin.Context, user *model.User) {
+	c.HTML(http.StatusForbidden, "suspended.html", gin.H{
+		"Title":   "Account suspended",
+		"Account": *user,
+	})
+}
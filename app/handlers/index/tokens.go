@@ -0,0 +1,94 @@
+package index
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/apitoken"
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxTokenLifetimeDays caps the expiry a user can pick for an access token.
+const maxTokenLifetimeDays = 365
+
+// CreateAccessToken issues a personal access token for the logged-in user.
+// The plaintext token is rendered once on the profile page and never stored.
+func CreateAccessToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		data := profileData(c, db, user)
+
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" || len(name) > 100 {
+			data["Error"] = "Token name must be between 1 and 100 characters."
+			c.HTML(http.StatusBadRequest, "profile.html", data)
+			return
+		}
+
+		scopes := c.PostFormArray("scopes")
+		if len(scopes) == 0 {
+			data["Error"] = "Select at least one scope."
+			c.HTML(http.StatusBadRequest, "profile.html", data)
+			return
+		}
+		for _, scope := range scopes {
+			if !apitoken.ValidScope(scope) {
+				data["Error"] = "Unknown scope: " + scope
+				c.HTML(http.StatusBadRequest, "profile.html", data)
+				return
+			}
+		}
+
+		var expiresAt *time.Time
+		if expiry := c.PostForm("expires_in_days"); expiry != "never" {
+			days, err := strconv.Atoi(expiry)
+			if err != nil || days < 1 || days > maxTokenLifetimeDays {
+				data["Error"] = "Choose a valid expiry."
+				c.HTML(http.StatusBadRequest, "profile.html", data)
+				return
+			}
+			t := time.Now().AddDate(0, 0, days)
+			expiresAt = &t
+		}
+
+		token, record, err := apitoken.Issue(db, user.ID, name, scopes, expiresAt)
+		if err != nil {
+			data["Error"] = "Failed to create the access token."
+			c.HTML(http.StatusInternalServerError, "profile.html", data)
+			return
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionTokenCreated,
+			ActorID:  audit.ID(user.ID),
+			TargetID: audit.ID(user.ID),
+			Metadata: map[string]interface{}{"token_id": record.ID, "name": name, "scopes": scopes},
+		})
+
+		data = profileData(c, db, user)
+		data["NewAccessToken"] = token
+		data["Message"] = "Access token created. Copy it now; it will not be shown again."
+		c.HTML(http.StatusOK, "profile.html", data)
+	}
+}
+
+// RevokeAccessToken deletes one of the logged-in user's access tokens.
+func RevokeAccessToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		result := db.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&model.PersonalAccessToken{})
+		if result.RowsAffected > 0 {
+			audit.Record(db, c, audit.Event{
+				Action:   audit.ActionTokenRevoked,
+				ActorID:  audit.ID(user.ID),
+				TargetID: audit.ID(user.ID),
+				Metadata: map[string]interface{}{"token_id": c.Param("id")},
+			})
+		}
+		c.Redirect(http.StatusFound, "/profile?token_revoked=1")
+	}
+}
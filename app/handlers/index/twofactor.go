@@ -0,0 +1,373 @@
+package index
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// twoFactorIssuer is the account issuer shown in authenticator apps.
+	twoFactorIssuer = "Scaffold"
+	// twoFactorPendingTTL bounds how long a password/OAuth login may wait
+	// for its second factor.
+	twoFactorPendingTTL = 5 * time.Minute
+	// recoveryCodeCount is the number of recovery codes issued at a time.
+	recoveryCodeCount = 10
+)
+
+// finishLogin completes a password or OAuth login. Suspended users get the
+// suspension page, and users with two-factor authentication enabled are sent
+// to the code prompt before user_id is written to the session.
+func finishLogin(c *gin.Context, db *gorm.DB, user *model.User, method string) {
+	if refuseSuspended(c, db, user, method) {
+		renderSuspended(c, user)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		session := sessions.Default(c)
+		session.Delete("user_id")
+		session.Set("2fa_user_id", user.ID)
+		session.Set("2fa_started_at", time.Now().Unix())
+		session.Set("2fa_method", method)
+		session.Save()
+		c.Redirect(http.StatusFound, "/login/2fa")
+		return
+	}
+
+	logIn(c, db, user, method, "")
+	c.Redirect(http.StatusFound, "/")
+}
+
+// pendingTwoFactorUser returns the user waiting at the code prompt, if any.
+func pendingTwoFactorUser(c *gin.Context, db *gorm.DB) (*model.User, bool) {
+	session := sessions.Default(c)
+	userID := session.Get("2fa_user_id")
+	startedAt, _ := session.Get("2fa_started_at").(int64)
+	if userID == nil || time.Since(time.Unix(startedAt, 0)) > twoFactorPendingTTL {
+		return nil, false
+	}
+
+	var user model.User
+	if err := db.First(&user, userID).Error; err != nil || !user.TwoFactorEnabled() || user.Suspended() {
+		return nil, false
+	}
+	return &user, true
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code for user. Accepted codes cannot be used again: a TOTP step is claimed
+// with a conditional update, so of concurrent requests with the same code
+// only one succeeds.
+func verifySecondFactor(db *gorm.DB, user *model.User, code string) bool {
+	secret, err := utils.Decrypt(user.TOTPSecret)
+	if err != nil {
+		utils.Logger.Error("Failed to decrypt TOTP secret", "err", err, "user_id", user.ID)
+		return false
+	}
+
+	if step, ok := utils.ValidateTOTP(secret, code, time.Now()); ok {
+		result := db.Model(&model.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+
+	return useRecoveryCode(db, user.ID, code)
+}
+
+// useRecoveryCode marks a matching unused recovery code as used.
+func useRecoveryCode(db *gorm.DB, userID uint, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+	result := db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.SignToken(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new
+// codes for one-time display.
+func generateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]model.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		records = append(records, model.RecoveryCode{UserID: userID, CodeHash: utils.SignToken(code)})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// twoFactorPromptData builds the template data for the code prompt. Users
+// with registered passkeys may use one instead of a code.
+func twoFactorPromptData(db *gorm.DB, user *model.User) gin.H {
+	data := gin.H{"Title": "Two-factor authentication"}
+	if settings.PasskeyEnabled() {
+		var count int64
+		db.Model(&model.PasskeyCredential{}).Where("user_id = ?", user.ID).Count(&count)
+		data["Passkey"] = count > 0
+	}
+	return data
+}
+
+func TwoFactorForm(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := pendingTwoFactorUser(c, db)
+		if !ok {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+		c.HTML(http.StatusOK, "login_2fa.html", twoFactorPromptData(db, user))
+	}
+}
+
+// TwoFactorLogin checks the code for a login waiting at the prompt. Wrong
+// codes count towards the same lockout as wrong passwords.
+func TwoFactorLogin(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := pendingTwoFactorUser(c, db)
+		if !ok {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+		renderError := func(msg string) {
+			data := twoFactorPromptData(db, user)
+			data["Error"] = msg
+			c.HTML(http.StatusOK, "login_2fa.html", data)
+		}
+
+		if msg := loginThrottled(user); msg != "" {
+			renderError(msg)
+			return
+		}
+		if !verifySecondFactor(db, user, c.PostForm("code")) {
+			recordLoginAttempt(db, c, user.Username, &user.ID, false)
+			registerLoginFailure(db, c, emailService, user)
+			if user.Locked() {
+				renderError(loginThrottled(user))
+				return
+			}
+			renderError("Invalid authentication code")
+			return
+		}
+
+		clearLoginFailures(db, user)
+		logIn(c, db, user, pendingLoginMethod(c), "totp")
+		c.Redirect(http.StatusFound, "/")
+	}
+}
+
+// pendingLoginMethod returns the first-factor method of the login waiting at
+// the code prompt.
+func pendingLoginMethod(c *gin.Context) string {
+	method, _ := sessions.Default(c).Get("2fa_method").(string)
+	return method
+}
+
+// twoFactorSettingsData builds the template data for the settings page.
+func twoFactorSettingsData(c *gin.Context, db *gorm.DB, user model.User) gin.H {
+	data := gin.H{
+		"Title":     "Two-factor authentication",
+		"User":      user,
+		"Required":  config.C.Auth.RequireAdmin2FA && rbac.Can(db, user.ID, rbac.PermAdminAccess),
+		"CSRFToken": c.GetString("csrf_token"),
+	}
+	addImpersonator(c, db, data)
+	if user.TwoFactorEnabled() {
+		var remaining int64
+		db.Model(&model.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+		data["RecoveryCodesRemaining"] = remaining
+	}
+	return data
+}
+
+// enrollmentData adds a pending TOTP secret to data, creating one in the
+// session if needed. The secret is only stored on the user once confirmed.
+func enrollmentData(c *gin.Context, user model.User, data gin.H) error {
+	session := sessions.Default(c)
+	secret, _ := session.Get("totp_enroll_secret").(string)
+	if secret == "" {
+		var err error
+		if secret, err = utils.NewTOTPSecret(); err != nil {
+			return err
+		}
+		session.Set("totp_enroll_secret", secret)
+		if err := session.Save(); err != nil {
+			return err
+		}
+	}
+	data["Secret"] = secret
+	data["OTPAuthURI"] = utils.TOTPURI(twoFactorIssuer, user.Email, secret)
+	return nil
+}
+
+func TwoFactorSettings(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		data := twoFactorSettingsData(c, db, user)
+		if c.Query("required") != "" && !user.TwoFactorEnabled() {
+			data["Error"] = "Administrators must enable two-factor authentication."
+		}
+		if !user.TwoFactorEnabled() {
+			if err := enrollmentData(c, user, data); err != nil {
+				data["Error"] = "Failed to start enrollment"
+			}
+		}
+		c.HTML(http.StatusOK, "two_factor.html", data)
+	}
+}
+
+func EnableTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		if user.TwoFactorEnabled() {
+			c.Redirect(http.StatusFound, "/profile/2fa")
+			return
+		}
+
+		session := sessions.Default(c)
+		secret, _ := session.Get("totp_enroll_secret").(string)
+		step, ok := utils.ValidateTOTP(secret, c.PostForm("code"), time.Now())
+		if secret == "" || !ok {
+			data := twoFactorSettingsData(c, db, user)
+			data["Error"] = "Invalid authentication code. Please try again."
+			enrollmentData(c, user, data)
+			c.HTML(http.StatusOK, "two_factor.html", data)
+			return
+		}
+
+		encrypted, err := utils.Encrypt(secret)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/profile/2fa")
+			return
+		}
+		now := time.Now()
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":     encrypted,
+			"totp_enabled_at": now,
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			c.Redirect(http.StatusFound, "/profile/2fa")
+			return
+		}
+		user.TOTPSecret = encrypted
+		user.TOTPEnabledAt = &now
+		user.TOTPLastStep = step
+		session.Delete("totp_enroll_secret")
+		session.Save()
+
+		codes, err := generateRecoveryCodes(db, user.ID)
+		data := twoFactorSettingsData(c, db, user)
+		data["Message"] = "Two-factor authentication is now enabled."
+		if err != nil {
+			data["Error"] = "Failed to generate recovery codes"
+		}
+		data["RecoveryCodes"] = codes
+		c.HTML(http.StatusOK, "two_factor.html", data)
+	}
+}
+
+func DisableTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		if !user.TwoFactorEnabled() {
+			c.Redirect(http.StatusFound, "/profile/2fa")
+			return
+		}
+
+		data := twoFactorSettingsData(c, db, user)
+		if data["Required"] == true {
+			data["Error"] = "Two-factor authentication is required for administrators."
+			c.HTML(http.StatusOK, "two_factor.html", data)
+			return
+		}
+		if !verifySecondFactor(db, &user, c.PostForm("code")) {
+			data["Error"] = "Invalid authentication code"
+			c.HTML(http.StatusOK, "two_factor.html", data)
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"totp_secret":     "",
+				"totp_enabled_at": nil,
+				"totp_last_step":  0,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error
+		})
+		if err != nil {
+			data["Error"] = "Failed to disable two-factor authentication"
+			c.HTML(http.StatusOK, "two_factor.html", data)
+			return
+		}
+
+		c.Redirect(http.StatusFound, "/profile/2fa")
+	}
+}
+
+func RegenerateRecoveryCodes(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		if !user.TwoFactorEnabled() {
+			c.Redirect(http.StatusFound, "/profile/2fa")
+			return
+		}
+
+		data := twoFactorSettingsData(c, db, user)
+		if !verifySecondFactor(db, &user, c.PostForm("code")) {
+			data["Error"] = "Invalid authentication code"
+			c.HTML(http.StatusOK, "two_factor.html", data)
+			return
+		}
+
+		codes, err := generateRecoveryCodes(db, user.ID)
+		if err != nil {
+			data["Error"] = "Failed to generate recovery codes"
+			c.HTML(http.StatusOK, "two_factor.html", data)
+			return
+		}
+		data = twoFactorSettingsData(c, db, user)
+		data["Message"] = "New recovery codes generated. Your old codes no longer work."
+		data["RecoveryCodes"] = codes
+		c.HTML(http.StatusOK, "two_factor.html", data)
+	}
+}
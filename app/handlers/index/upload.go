@@ -1,22 +1,23 @@
 package index
 
 import (
+	"errors"
 	"net/http"
-	"path/filepath"
-	"strings"
 
+	"github.com/dariubs/scaffold/app/audit"
 	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/storage"
+	"github.com/dariubs/scaffold/app/uploads"
 	"github.com/dariubs/scaffold/app/utils"
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 // UploadProfileImage handles profile image upload
-func UploadProfileImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc {
+func UploadProfileImage(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		userID := session.Get("user_id")
+		// Set by the auth middleware for both session and token requests
+		userID, _ := c.Get("user_id")
 
 		if userID == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
@@ -30,69 +31,62 @@ func UploadProfileImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc
 			return
 		}
 
-		// Validate file type
-		allowedTypes := []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		isValidType := false
-		for _, allowedType := range allowedTypes {
-			if ext == allowedType {
-				isValidType = true
-				break
-			}
-		}
-
-		if !isValidType {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Only JPG, PNG, GIF, and WebP are allowed"})
+		// Get current user
+		var user model.User
+		if err := db.First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
 			return
 		}
 
-		// Validate file size (max 5MB)
-		if file.Size > 5*1024*1024 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File too large. Maximum size is 5MB"})
+		// Validate, store and record the file
+		upload, err := uploads.Save(c.Request.Context(), db, store, user.ID, file, "profiles")
+		var invalid *uploads.ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Message})
 			return
 		}
-
-		// Upload to R2
-		fileURL, err := r2Service.UploadProfileImage(file, userID.(uint))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
 			return
 		}
-
-		// Get current user
-		var user model.User
-		if err := db.First(&user, userID).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
-			return
-		}
-
-		// Delete old profile image if exists
-		if user.AvatarURL != "" {
-			if err := r2Service.DeleteFile(user.AvatarURL); err != nil {
-				// Log error but don't fail the upload
-				// You might want to add proper logging here
-			}
-		}
+		fileURL := store.URL(upload.Key)
 
 		// Update user profile with new image URL
+		oldURL := user.AvatarURL
 		user.AvatarURL = fileURL
+		user.AvatarVariants = upload.Variants
 		if err := db.Save(&user).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
 			return
 		}
 
+		// Delete old profile image if exists (OAuth avatars are left alone)
+		if oldURL != "" {
+			if err := uploads.Discard(c.Request.Context(), db, store, user.ID, oldURL); err != nil {
+				// Log error but don't fail the upload
+				utils.Logger.Warn("Failed to delete old profile image", "err", err, "user_id", user.ID)
+			}
+		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionAvatarChanged,
+			ActorID:  audit.ID(user.ID),
+			TargetID: audit.ID(user.ID),
+			Metadata: map[string]interface{}{"url": fileURL},
+		})
+
 		c.JSON(http.StatusOK, gin.H{
-			"message":   "Profile image uploaded successfully",
-			"image_url": fileURL,
+			"message":    "Profile image uploaded successfully",
+			"image_url":  fileURL,
+			"image_urls": user.AvatarURLs(),
 		})
 	}
 }
 
 // UploadImage handles general image upload
-func UploadImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc {
+func UploadImage(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		userID := session.Get("user_id")
+		// Set by the auth middleware for both session and token requests
+		userID, _ := c.Get("user_id")
 
 		if userID == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
@@ -112,34 +106,22 @@ func UploadImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc {
 			folder = "general"
 		}
 
-		// Validate file type
-		allowedTypes := []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		isValidType := false
-		for _, allowedType := range allowedTypes {
-			if ext == allowedType {
-				isValidType = true
-				break
-			}
-		}
-
-		if !isValidType {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Only JPG, PNG, GIF, and WebP are allowed"})
+		// Validate, store and record the file
+		upload, err := uploads.Save(c.Request.Context(), db, store, userID.(uint), file, folder)
+		var invalid *uploads.ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Message})
 			return
 		}
-
-		// Validate file size (max 10MB for general images)
-		if file.Size > 10*1024*1024 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File too large. Maximum size is 10MB"})
+		if errors.Is(err, storage.ErrInvalidFolder) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder. Use letters, digits, dashes and underscores"})
 			return
 		}
-
-		// Upload to R2
-		fileURL, err := r2Service.UploadImage(file, folder)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
 			return
 		}
+		fileURL := store.URL(upload.Key)
 
 		c.JSON(http.StatusOK, gin.H{
 			"message":   "Image uploaded successfully",
@@ -150,10 +132,10 @@ func UploadImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc {
 }
 
 // DeleteImage handles image deletion
-func DeleteImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc {
+func DeleteImage(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		userID := session.Get("user_id")
+		// Set by the auth middleware for both session and token requests
+		userID, _ := c.Get("user_id")
 
 		if userID == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
@@ -166,11 +148,25 @@ func DeleteImage(db *gorm.DB, r2Service *utils.R2Service) gin.HandlerFunc {
 			return
 		}
 
-		// Delete from R2
-		if err := r2Service.DeleteFile(imageURL); err != nil {
+		// Only the owner may delete a file
+		upload, err := uploads.DeleteOwned(c.Request.Context(), db, store, userID.(uint), imageURL)
+		switch {
+		case errors.Is(err, uploads.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		case errors.Is(err, uploads.ErrNotOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own files"})
+			return
+		case err != nil:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
 			return
 		}
+		audit.Record(db, c, audit.Event{
+			Action:   audit.ActionFileDeleted,
+			ActorID:  audit.Actor(c),
+			TargetID: audit.ID(upload.UserID),
+			Metadata: map[string]interface{}{"url": imageURL, "upload_id": upload.ID},
+		})
 
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Image deleted successfully",
@@ -0,0 +1,135 @@
+package index
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// verificationResendInterval is the minimum time between verification emails
+// for the same user.
+const verificationResendInterval = time.Minute
+
+// sendEmailVerification issues a fresh verification token for user, replacing
+// any outstanding ones, and emails the confirmation link.
+func sendEmailVerification(db *gorm.DB, emailService *utils.EmailService, user *model.User) error {
+	token, hash, err := utils.NewToken()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.EmailVerificationToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.EmailVerificationToken{
+			UserID:    user.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(config.C.Auth.EmailVerificationTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if emailService == nil {
+		utils.Logger.Warn("Email service not configured; verification link not sent", "user_id", user.ID)
+		return nil
+	}
+	link := config.C.URL("/verify-email?token=" + url.QueryEscape(token))
+	return emailService.SendVerification(user.Email, user.Name, link)
+}
+
+// VerifyEmail confirms an email address from the emailed link. Without a token
+// it shows the "check your inbox" page for the logged-in user.
+func VerifyEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			data := gin.H{"Title": "Verify your email"}
+			if userID := sessions.Default(c).Get("user_id"); userID != nil {
+				var user model.User
+				if db.First(&user, userID).Error == nil {
+					data["User"] = user
+				}
+			}
+			if c.Query("sent") != "" {
+				data["Message"] = "A new verification email has been sent."
+			}
+			if c.Query("error") == "too_soon" {
+				data["Error"] = "Please wait a minute before requesting another email."
+			}
+			c.HTML(http.StatusOK, "verify_email.html", data)
+			return
+		}
+
+		var record model.EmailVerificationToken
+		if err := db.Where("token_hash = ?", utils.SignToken(token)).First(&record).Error; err != nil || time.Now().After(record.ExpiresAt) {
+			c.HTML(http.StatusBadRequest, "verify_email.html", gin.H{
+				"Title": "Verify your email",
+				"Error": "This verification link is invalid or has expired.",
+			})
+			return
+		}
+
+		now := time.Now()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&model.User{}).Where("id = ?", record.UserID).Update("email_verified_at", now).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", record.UserID).Delete(&model.EmailVerificationToken{}).Error
+		})
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "verify_email.html", gin.H{
+				"Title": "Verify your email",
+				"Error": "Failed to verify email address",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "verify_email.html", gin.H{
+			"Title":    "Email verified",
+			"Verified": true,
+		})
+	}
+}
+
+// ResendVerification emails a new verification link to the logged-in user.
+func ResendVerification(db *gorm.DB, emailService *utils.EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := sessions.Default(c).Get("user_id")
+		if userID == nil {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		var user model.User
+		if err := db.First(&user, userID).Error; err != nil {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+		if user.EmailVerified() {
+			c.Redirect(http.StatusFound, "/")
+			return
+		}
+
+		var last model.EmailVerificationToken
+		if db.Where("user_id = ?", user.ID).Order("created_at DESC").First(&last).Error == nil &&
+			time.Since(last.CreatedAt) < verificationResendInterval {
+			c.Redirect(http.StatusFound, "/verify-email?error=too_soon")
+			return
+		}
+
+		if err := sendEmailVerification(db, emailService, &user); err != nil {
+			utils.Logger.Error("Failed to resend verification email", "err", err, "user_id", user.ID)
+		}
+		c.Redirect(http.StatusFound, "/verify-email?sent=1")
+	}
+}
@@ -0,0 +1,131 @@
+// Package impersonation lets an administrator sign in as another user to see
+// exactly what they see. The admin's ID is kept in the session next to the
+// impersonated user_id so Stop can switch back.
+package impersonation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Session keys holding the impersonating admin and when they signed in.
+const (
+	sessionAdminID       = "impersonator_id"
+	sessionAdminAuthTime = "impersonator_auth_time"
+)
+
+// ContextImpersonator is the gin context key under which the auth middleware
+// stores the impersonating admin.
+const ContextImpersonator = "impersonator"
+
+var (
+	// ErrAdminTarget is returned when the target can open the admin panel.
+	ErrAdminTarget = errors.New("impersonation: cannot impersonate an administrator")
+	// ErrSelf is returned when an admin tries to impersonate themselves.
+	ErrSelf = errors.New("impersonation: cannot impersonate yourself")
+	// ErrActive is returned when the session is already impersonating.
+	ErrActive = errors.New("impersonation: already impersonating a user")
+	// ErrNotActive is returned by Stop when there is nothing to stop.
+	ErrNotActive = errors.New("impersonation: not impersonating")
+	// ErrInvalid is returned when the impersonating admin no longer exists or
+	// has changed their password since starting.
+	ErrInvalid = errors.New("impersonation: admin session is no longer valid")
+)
+
+// Start switches the session from admin to target. Targets that hold
+// admin.access cannot be impersonated.
+func Start(c *gin.Context, db *gorm.DB, admin, target model.User) error {
+	session := sessions.Default(c)
+	switch {
+	case session.Get(sessionAdminID) != nil:
+		return ErrActive
+	case admin.ID == target.ID:
+		return ErrSelf
+	case rbac.Can(db, target.ID, rbac.PermAdminAccess):
+		return ErrAdminTarget
+	}
+
+	authTime, _ := session.Get("auth_time").(int64)
+	session.Set(sessionAdminID, admin.ID)
+	session.Set(sessionAdminAuthTime, authTime)
+	session.Set("user_id", target.ID)
+	session.Set("auth_time", time.Now().Unix())
+	session.Delete("org_id")
+	if err := session.Save(); err != nil {
+		return err
+	}
+
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionImpersonationStarted,
+		ActorID:  audit.ID(admin.ID),
+		TargetID: audit.ID(target.ID),
+	})
+	return nil
+}
+
+// Stop switches the session back to the impersonating admin and returns them
+// together with the ID of the user who was impersonated.
+func Stop(c *gin.Context, db *gorm.DB) (model.User, uint, error) {
+	session := sessions.Default(c)
+	admin, err := Impersonator(c, db)
+	if err != nil {
+		return model.User{}, 0, err
+	}
+	if admin == nil {
+		return model.User{}, 0, ErrNotActive
+	}
+
+	targetID, _ := session.Get("user_id").(uint)
+	authTime, _ := session.Get(sessionAdminAuthTime).(int64)
+	session.Delete(sessionAdminID)
+	session.Delete(sessionAdminAuthTime)
+	session.Delete("org_id")
+	session.Set("user_id", admin.ID)
+	session.Set("auth_time", authTime)
+	if err := session.Save(); err != nil {
+		return model.User{}, 0, err
+	}
+
+	audit.Record(db, c, audit.Event{
+		Action:   audit.ActionImpersonationStopped,
+		ActorID:  audit.ID(admin.ID),
+		TargetID: audit.ID(targetID),
+	})
+	return *admin, targetID, nil
+}
+
+// Impersonator returns the admin impersonating the session's user, or nil
+// when the session is not an impersonation. It returns ErrInvalid when the
+// admin has been deleted or has changed their password since signing in;
+// callers should then clear the session.
+func Impersonator(c *gin.Context, db *gorm.DB) (*model.User, error) {
+	session := sessions.Default(c)
+	id := session.Get(sessionAdminID)
+	if id == nil {
+		return nil, nil
+	}
+	var admin model.User
+	if err := db.First(&admin, id).Error; err != nil {
+		return nil, ErrInvalid
+	}
+	if admin.PasswordChangedAt != nil {
+		authTime, _ := session.Get(sessionAdminAuthTime).(int64)
+		if authTime < admin.PasswordChangedAt.Unix() {
+			return nil, ErrInvalid
+		}
+	}
+	return &admin, nil
+}
+
+// Active reports whether the auth middleware found an impersonation on c.
+func Active(c *gin.Context) bool {
+	_, ok := c.Get(ContextImpersonator)
+	return ok
+}
@@ -0,0 +1,36 @@
+// Command auditprune deletes audit events older than AUDIT_RETENTION_DAYS.
+// Run it from cron, e.g. daily.
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/dariubs/scaffold/app/audit"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/database"
+)
+
+func main() {
+	err := config.Load()
+	if err != nil {
+		log.Fatal("Error loading configuration:", err)
+	}
+
+	days := flag.Int("days", config.C.Audit.RetentionDays, "delete events older than this many days (0 keeps everything)")
+	flag.Parse()
+	if *days <= 0 {
+		log.Println("Audit retention is disabled; nothing to prune")
+		return
+	}
+
+	database.InitDB()
+
+	cutoff := time.Now().AddDate(0, 0, -*days)
+	n, err := audit.Prune(database.DB, cutoff)
+	if err != nil {
+		log.Fatal("Failed to prune audit events:", err)
+	}
+	log.Printf("Deleted %d audit events recorded before %s", n, cutoff.Format(time.RFC3339))
+}
@@ -12,14 +12,12 @@ import (
 
 	"github.com/dariubs/scaffold/app/config"
 	"github.com/dariubs/scaffold/app/database"
-	"github.com/dariubs/scaffold/app/handlers/admin"
-	"github.com/dariubs/scaffold/app/handlers/health"
 	"github.com/dariubs/scaffold/app/handlers/index"
-	"github.com/dariubs/scaffold/app/middleware"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/dariubs/scaffold/app/storage"
 	"github.com/dariubs/scaffold/app/utils"
-	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
-	"github.com/gin-gonic/gin"
 )
 
 func main() {
@@ -32,20 +30,32 @@ func main() {
 	// Initialize database
 	database.InitDB()
 
-	// Initialize R2 service
-	r2Service, err := utils.NewR2Service()
+	// Load runtime settings saved from the admin panel
+	if err := settings.Init(database.DB); err != nil {
+		log.Printf("Warning: failed to load settings, using defaults: %v", err)
+	}
+
+	// Initialize file storage (driver selected by STORAGE_DRIVER)
+	store, err := storage.New()
 	if err != nil {
-		log.Printf("Warning: R2 service not available: %v", err)
-		r2Service = nil
+		log.Printf("Warning: file storage not available: %v", err)
+		store = nil
 	}
 
 	// Initialize email service (Resend; optional)
 	emailService, _ := utils.NewEmailService()
 
-	r := gin.Default()
+	// Initialize WebAuthn relying party (passkeys; optional)
+	webAuthn, err := utils.NewWebAuthn()
+	if err != nil {
+		log.Printf("Warning: passkey login not available: %v", err)
+		webAuthn = nil
+	}
+
+	r, spec := setupRouter(database.DB, store, emailService, webAuthn)
 
 	// Load HTML templates from both index and admin directories
-	t, err := template.New("").ParseGlob("views/index/*.html")
+	t, err := template.New("").Funcs(rbac.FuncMap()).ParseGlob("views/index/*.html")
 	if err != nil {
 		log.Fatal("Failed to parse index templates:", err)
 	}
@@ -55,65 +65,25 @@ func main() {
 	}
 	r.SetHTMLTemplate(t)
 
-	// Session middleware
-	r.Use(sessions.Sessions("scaffoldsession", cookie.NewStore([]byte(config.C.Session.Secret))))
-
-	// Health check routes (before other middleware)
-	healthGroup := r.Group("")
-	{
-		healthGroup.GET("/health", health.Health())
-		healthGroup.GET("/readiness", health.Readiness())
-	}
-
-	// Routes
-	r.GET("/", index.Home(database.DB))
-	r.GET("/login", index.LoginForm())
-	r.POST("/login", index.Login(database.DB))
-	r.GET("/register", index.RegisterForm())
-	r.POST("/register", index.Register(database.DB, emailService))
-	r.GET("/logout", index.Logout())
-
-	// Protected routes
-	protected := r.Group("")
-	protected.Use(middleware.RequireAuth(database.DB))
-	{
-		protected.GET("/profile", index.Profile(database.DB))
-	}
-
-	// OAuth routes (only for enabled providers)
-	if config.C.OAuthGoogleEnabled() {
-		r.GET("/auth/google", index.GoogleLogin())
-		r.GET("/auth/google/callback", index.GoogleCallback(database.DB))
-	}
-	if config.C.OAuthGitHubEnabled() {
-		r.GET("/auth/github", index.GitHubLogin())
-		r.GET("/auth/github/callback", index.GitHubCallback(database.DB))
-	}
-	if config.C.OAuthLinkedInEnabled() {
-		r.GET("/auth/linkedin", index.LinkedInLogin())
-		r.GET("/auth/linkedin/callback", index.LinkedInCallback(database.DB))
-	}
-	if config.C.OAuthXEnabled() {
-		r.GET("/auth/x", index.XLogin())
-		r.GET("/auth/x/callback", index.XCallback(database.DB))
-	}
-
-	// File upload routes (only if R2 service is available, protected)
-	if r2Service != nil {
-		uploadGroup := r.Group("")
-		uploadGroup.Use(middleware.RequireAuth(database.DB))
-		{
-			uploadGroup.POST("/upload/profile-image", index.UploadProfileImage(database.DB, r2Service))
-			uploadGroup.POST("/upload/image", index.UploadImage(database.DB, r2Service))
-			uploadGroup.POST("/delete/image", index.DeleteImage(database.DB, r2Service))
+	// Prune expired sessions and old login attempts periodically
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := sessionstore.PruneExpired(database.DB); err != nil {
+				log.Printf("Warning: failed to prune expired sessions: %v", err)
+			}
+			if _, err := index.PruneLoginAttempts(database.DB, time.Now().Add(-config.C.Auth.LoginAttemptRetention)); err != nil {
+				log.Printf("Warning: failed to prune login attempts: %v", err)
+			}
 		}
-	}
+	}()
 
-	// Admin routes (mount at configurable base path)
-	adminGroup := r.Group("/" + config.C.Server.AdminPath)
-	adminGroup.Use(middleware.RequireAdmin(database.DB))
-	{
-		adminGroup.GET("/", admin.AdminHome())
+	// Report JSON routes missing from the OpenAPI document, and descriptions
+	// of routes that no longer exist
+	for _, route := range spec.Undocumented(r.Routes(), documentedPrefixes...) {
+		log.Printf("Warning: route %s is not described in the OpenAPI document", route)
+	}
+	for _, route := range spec.Unregistered(r.Routes()) {
+		log.Printf("Warning: OpenAPI operation %s has no registered route", route)
 	}
 
 	srv := &http.Server{
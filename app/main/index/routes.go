@@ -0,0 +1,217 @@
+package main
+
+import (
+	"github.com/dariubs/scaffold/app/apitoken"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/handlers/admin"
+	"github.com/dariubs/scaffold/app/handlers/api"
+	"github.com/dariubs/scaffold/app/handlers/health"
+	"github.com/dariubs/scaffold/app/handlers/index"
+	"github.com/dariubs/scaffold/app/middleware"
+	"github.com/dariubs/scaffold/app/openapi"
+	"github.com/dariubs/scaffold/app/rbac"
+	"github.com/dariubs/scaffold/app/sessionstore"
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/dariubs/scaffold/app/storage"
+	"github.com/dariubs/scaffold/app/tenant"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+// documentedPrefixes are the path prefixes of routes that return JSON and
+// must be described in the OpenAPI document.
+var documentedPrefixes = []string{"/api/", "/health", "/readiness", "/upload/", "/delete/"}
+
+// setupRouter registers every route and returns the router with the OpenAPI
+// document describing its JSON routes. Optional services that are not
+// configured are nil: routes depending on store are skipped without file
+// storage, and passkey routes without webAuthn. HTML templates are loaded by
+// the caller.
+func setupRouter(db *gorm.DB, store storage.Storage, emailService *utils.EmailService, webAuthn *webauthn.WebAuthn) (*gin.Engine, *openapi.Spec) {
+	r := gin.Default()
+	// Only the configured proxies may set the client IP used for rate limits,
+	// login blocking, sessions and the audit log
+	if err := r.SetTrustedProxies(config.C.Server.TrustedProxies); err != nil {
+		utils.Logger.Warn("Invalid trusted proxies; trusting none", "err", err)
+		r.SetTrustedProxies(nil)
+	}
+
+	// Session middleware (server-side sessions stored in the database)
+	r.Use(sessionstore.ClientIP(), sessions.Sessions("scaffoldsession", sessionstore.NewStore(db, []byte(config.C.Session.Secret))))
+
+	// OpenAPI document, built from the descriptions next to each handler
+	spec := openapi.New("Scaffold API", "1.0.0", "JSON endpoints of the Scaffold application.")
+	health.Describe(spec)
+	api.Describe(spec)
+	if store != nil {
+		index.DescribeUploads(spec)
+		api.DescribeUploads(spec)
+	}
+	r.GET("/openapi.json", spec.Handler())
+	r.GET("/docs/api", index.APIDocs())
+
+	// Health check routes (before other middleware)
+	healthGroup := r.Group("")
+	{
+		healthGroup.GET("/health", health.Health())
+		healthGroup.GET("/readiness", health.Readiness())
+	}
+
+	// Routes
+	r.GET("/", middleware.CSRF(), index.Home(db))
+	r.GET("/login", index.LoginForm())
+	r.POST("/login", middleware.RateLimit("20-M"), index.Login(db, emailService))
+	r.GET("/register", index.RegisterForm())
+	r.POST("/register", index.Register(db, emailService))
+	r.GET("/logout", index.Logout())
+	r.GET("/login/2fa", index.TwoFactorForm(db))
+	r.POST("/login/2fa", middleware.RateLimit("10-M"), index.TwoFactorLogin(db, emailService))
+	r.GET("/verify-email", index.VerifyEmail(db))
+	r.POST("/verify-email/resend", index.ResendVerification(db, emailService))
+	r.GET("/unlock-account", index.UnlockAccount(db))
+
+	// Password reset routes (rate limited per IP; only while password login is on)
+	passwordReset := r.Group("")
+	passwordReset.Use(middleware.RequireSetting(settings.LoginPassword))
+	{
+		passwordReset.GET("/forgot-password", index.ForgotPasswordForm())
+		passwordReset.POST("/forgot-password", middleware.RateLimit("5-H"), index.ForgotPassword(db, emailService))
+		passwordReset.GET("/reset-password", index.ResetPasswordForm(db))
+		passwordReset.POST("/reset-password", middleware.RateLimit("10-H"), index.ResetPassword(db))
+	}
+
+	// Protected routes (forms carry the session's CSRF token)
+	protected := r.Group("")
+	protected.Use(middleware.RequireAuth(db), middleware.ResolveOrganization(db), middleware.CSRF())
+	{
+		protected.GET("/profile", index.Profile(db))
+		protected.POST("/profile/sessions/:id/revoke", index.RevokeSession(db))
+		protected.POST("/profile/sessions/revoke-others", index.RevokeOtherSessions(db))
+		protected.GET("/profile/2fa", index.TwoFactorSettings(db))
+		protected.POST("/profile/2fa/enable", middleware.ForbidImpersonation(), index.EnableTwoFactor(db))
+		protected.POST("/profile/2fa/disable", middleware.ForbidImpersonation(), index.DisableTwoFactor(db))
+		protected.POST("/profile/2fa/recovery-codes", middleware.ForbidImpersonation(), index.RegenerateRecoveryCodes(db))
+		protected.GET("/profile/connections/:provider/link", middleware.ForbidImpersonation(), index.LinkOAuthProvider(db))
+		protected.POST("/profile/connections/:provider/unlink", middleware.ForbidImpersonation(), index.UnlinkOAuthProvider(db))
+		protected.POST("/profile/tokens", middleware.ForbidImpersonation(), index.CreateAccessToken(db))
+		protected.POST("/profile/tokens/:id/revoke", index.RevokeAccessToken(db))
+		protected.GET("/orgs", index.Organizations(db))
+		protected.POST("/orgs", index.CreateOrganization(db))
+		protected.POST("/orgs/switch", index.SwitchOrganization(db))
+		protected.POST("/orgs/invitations", middleware.RequireOrganizationRole(tenant.RoleAdmin), index.InviteMember(db, emailService))
+		protected.POST("/orgs/invitations/:id/revoke", middleware.RequireOrganizationRole(tenant.RoleAdmin), index.RevokeInvitation(db))
+		protected.POST("/orgs/members/:id/remove", middleware.RequireOrganizationRole(tenant.RoleAdmin), index.RemoveMember(db))
+		protected.POST("/invitations/accept", index.AcceptInvitation(db))
+		protected.POST("/impersonation/stop", index.StopImpersonating(db))
+	}
+
+	// Organization invitation links (declining works without signing in)
+	r.GET("/invitations", middleware.CSRF(), index.Invitation(db))
+	r.POST("/invitations/decline", middleware.CSRF(), index.DeclineInvitation(db))
+
+	// OAuth routes (providers turned off in settings redirect back to the login page)
+	r.GET("/auth/:provider", index.OAuthLogin())
+	r.GET("/auth/:provider/callback", index.OAuthCallback(db))
+
+	// Magic link routes (only while magic link login is on)
+	r.POST("/login/magic", middleware.RequireSetting(settings.LoginMagicLink), middleware.RateLimit("10-H"), index.RequestMagicLink(db, emailService))
+	r.GET("/login/magic", middleware.RequireSetting(settings.LoginMagicLink), index.MagicLinkLogin(db))
+
+	// Passkey routes (only if the WebAuthn relying party is configured, and
+	// only while passkey login is on)
+	if webAuthn != nil {
+		passkeyLogin := r.Group("")
+		passkeyLogin.Use(middleware.RequireSetting(settings.LoginPasskey))
+		passkeyLogin.POST("/auth/passkey/begin", index.BeginPasskeyLogin(webAuthn))
+		passkeyLogin.POST("/auth/passkey/finish", index.FinishPasskeyLogin(db, webAuthn))
+		passkeyLogin.POST("/login/2fa/passkey/begin", index.BeginPasskeySecondFactor(db, webAuthn))
+		passkeyLogin.POST("/login/2fa/passkey/finish", index.FinishPasskeySecondFactor(db, webAuthn))
+
+		passkeyGroup := r.Group("/profile/passkeys")
+		passkeyGroup.Use(middleware.RequireSetting(settings.LoginPasskey), middleware.RequireAuth(db), middleware.ForbidImpersonation(), middleware.CSRF())
+		{
+			passkeyGroup.POST("/begin", index.BeginPasskeyRegistration(db, webAuthn))
+			passkeyGroup.POST("/finish", index.FinishPasskeyRegistration(db, webAuthn))
+			passkeyGroup.POST("/:id/delete", index.DeletePasskey(db))
+		}
+	}
+
+	// Files stored on local disk are served from STORAGE_URL_PATH
+	if local, ok := store.(*storage.Local); ok {
+		r.Static(config.C.Storage.URLPath, local.Dir())
+	}
+
+	// File upload routes (only if storage is available, protected; also
+	// accept access tokens with the uploads scope, which need no CSRF token)
+	if store != nil {
+		uploadGroup := r.Group("")
+		uploadGroup.Use(middleware.RequireAuth(db, apitoken.ScopeUploads), middleware.CSRF())
+		{
+			uploadGroup.POST("/upload/profile-image", index.UploadProfileImage(db, store))
+			uploadGroup.POST("/upload/image", index.UploadImage(db, store))
+			uploadGroup.POST("/delete/image", index.DeleteImage(db, store))
+		}
+	}
+
+	// JSON API (session or personal access token auth)
+	v1 := r.Group("/api/v1")
+	v1.Use(middleware.RateLimit("300-M"))
+	{
+		v1.GET("/me", middleware.RequireAPIAuth(db, apitoken.ScopeProfileRead), api.Me())
+		v1.PATCH("/me", middleware.RequireAPIAuth(db, apitoken.ScopeProfileWrite), api.UpdateMe(db))
+		if store != nil {
+			uploads := v1.Group("")
+			uploads.Use(middleware.RequireAPIAuth(db, apitoken.ScopeUploads))
+			uploads.POST("/me/avatar", api.UploadAvatar(db, store))
+			uploads.GET("/uploads", api.ListUploads(db, store))
+			uploads.POST("/uploads", api.UploadImage(db, store))
+			uploads.DELETE("/uploads", api.DeleteImage(db, store))
+		}
+
+		users := v1.Group("/users")
+		users.Use(middleware.RequireAPIAuth(db, apitoken.ScopeAdmin), middleware.RequireAPIPermission(db, rbac.PermAdminAccess))
+		users.GET("", middleware.RequireAPIPermission(db, rbac.PermUsersView), api.ListUsers(db))
+		users.GET("/:id", middleware.RequireAPIPermission(db, rbac.PermUsersView), api.GetUser(db))
+		users.PATCH("/:id", middleware.RequireAPIPermission(db, rbac.PermUsersEdit), api.UpdateUser(db))
+		users.DELETE("/:id/sessions", middleware.RequireAPIPermission(db, rbac.PermSessionsRevoke), api.RevokeUserSessions(db))
+	}
+
+	// Admin routes (served under the admin path setting, which can change at runtime)
+	adminGroup := r.Group("/:admin")
+	adminGroup.Use(middleware.AdminPath(), middleware.RequireAdmin(db), middleware.CSRF())
+	{
+		adminGroup.GET("/", admin.AdminHome())
+		adminGroup.GET("/users", middleware.RequirePermission(db, rbac.PermUsersView), admin.Users(db))
+		adminGroup.GET("/users/:id", middleware.RequirePermission(db, rbac.PermUsersView), admin.User(db))
+		adminGroup.POST("/users/:id", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.UpdateUser(db))
+		adminGroup.POST("/users/:id/admin", middleware.RequirePermission(db, rbac.PermRolesManage), admin.ToggleAdmin(db))
+		adminGroup.POST("/users/:id/delete", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.DeleteUser(db))
+		adminGroup.POST("/users/:id/restore", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.RestoreUser(db))
+		adminGroup.POST("/users/:id/password-reset", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.ForcePasswordReset(db, emailService))
+		adminGroup.POST("/users/:id/suspend", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.SuspendUser(db))
+		adminGroup.POST("/users/:id/unsuspend", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.UnsuspendUser(db))
+		adminGroup.POST("/users/:id/impersonate", middleware.RequirePermission(db, rbac.PermImpersonate), admin.Impersonate(db))
+		adminGroup.POST("/sessions/revoke", middleware.RequirePermission(db, rbac.PermSessionsRevoke), admin.RevokeUserSessions(db))
+		adminGroup.POST("/users/unlock", middleware.RequirePermission(db, rbac.PermUsersEdit), admin.UnlockUser(db))
+		adminGroup.GET("/audit", middleware.RequirePermission(db, rbac.PermAuditView), admin.AuditLog(db))
+		adminGroup.GET("/audit/export", middleware.RequirePermission(db, rbac.PermAuditView), admin.ExportAuditLog(db))
+		adminGroup.GET("/roles", middleware.RequirePermission(db, rbac.PermRolesManage), admin.Roles(db))
+		adminGroup.POST("/roles/assign", middleware.RequirePermission(db, rbac.PermRolesManage), admin.AssignRole(db))
+		adminGroup.POST("/roles/unassign", middleware.RequirePermission(db, rbac.PermRolesManage), admin.UnassignRole(db))
+		adminGroup.GET("/settings", middleware.RequirePermission(db, rbac.PermSettingsManage), admin.Settings())
+		adminGroup.POST("/settings", middleware.RequirePermission(db, rbac.PermSettingsManage), admin.UpdateSetting(db))
+		adminGroup.POST("/settings/reset", middleware.RequirePermission(db, rbac.PermSettingsManage), admin.ResetSetting(db))
+		adminGroup.GET("/uploads", middleware.RequirePermission(db, rbac.PermUploadsManage), admin.Uploads(db, store))
+		if store != nil {
+			adminGroup.POST("/uploads/:id/delete", middleware.RequirePermission(db, rbac.PermUploadsManage), admin.DeleteUpload(db, store))
+		}
+	}
+
+	// Keep the admin path from shadowing the application's own routes
+	settings.ReserveRoutes(r.Routes())
+
+	return r, spec
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testRouter builds the router with every optional service configured, so
+// that all routes are registered. The database is never connected to.
+func testRouter(t *testing.T) (*gin.Engine, []string, []string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	config.C = &config.Config{}
+	config.C.Session.Secret = "test-secret"
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "Scaffold",
+		RPOrigins:     []string{"http://localhost:3782"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, spec := setupRouter(db, storage.NewMemory("http://localhost:3782/files/"), nil, wa)
+	return r, spec.Undocumented(r.Routes(), documentedPrefixes...), spec.Unregistered(r.Routes())
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	_, undocumented, unregistered := testRouter(t)
+	for _, route := range undocumented {
+		t.Errorf("route %s is not described in the OpenAPI document", route)
+	}
+	for _, route := range unregistered {
+		t.Errorf("OpenAPI operation %s has no registered route", route)
+	}
+}
+
+func TestRoutesRegistered(t *testing.T) {
+	r, _, _ := testRouter(t)
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+	for _, route := range []string{
+		"GET /openapi.json",
+		"POST /login",
+		"POST /upload/image",
+		"POST /api/v1/uploads",
+		"POST /auth/passkey/begin",
+		"POST /:admin/uploads/:id/delete",
+	} {
+		if !registered[route] {
+			t.Errorf("%s is not registered", route)
+		}
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/dariubs/scaffold/app/config"
 	"github.com/dariubs/scaffold/app/database"
 	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/rbac"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -40,7 +42,178 @@ func runMigrations() error {
 		return err
 	}
 
-	// Migration 2: Add any additional indexes or constraints
+	// Migration 2: Create email verification tokens table
+	log.Println("Running migration: Create email verification tokens table")
+	err = db.AutoMigrate(&model.EmailVerificationToken{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 3: Create password reset tokens table
+	log.Println("Running migration: Create password reset tokens table")
+	err = db.AutoMigrate(&model.PasswordResetToken{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 4: Create two-factor recovery codes table
+	log.Println("Running migration: Create recovery codes table")
+	err = db.AutoMigrate(&model.RecoveryCode{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 5: Create passkey credentials table
+	log.Println("Running migration: Create passkey credentials table")
+	err = db.AutoMigrate(&model.PasskeyCredential{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 6: Create magic link tokens table
+	log.Println("Running migration: Create magic link tokens table")
+	err = db.AutoMigrate(&model.MagicLinkToken{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 7: Create sessions table
+	log.Println("Running migration: Create sessions table")
+	err = db.AutoMigrate(&model.Session{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 8: Create login attempts table
+	log.Println("Running migration: Create login attempts table")
+	err = db.AutoMigrate(&model.LoginAttempt{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 9: Create account unlock tokens table
+	log.Println("Running migration: Create account unlock tokens table")
+	err = db.AutoMigrate(&model.AccountUnlockToken{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 10: Create audit events table
+	log.Println("Running migration: Create audit events table")
+	err = db.AutoMigrate(&model.AuditEvent{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 11: Create OAuth identities table
+	log.Println("Running migration: Create OAuth identities table")
+	err = db.AutoMigrate(&model.OAuthIdentity{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 12: Move per-provider ID columns on users into OAuth identities
+	log.Println("Running migration: Move OAuth provider IDs into identities")
+	providerColumns := []struct{ column, provider string }{
+		{"google_id", "google"},
+		{"git_hub_id", "github"},
+		{"linked_in_id", "linkedin"},
+		{"x_id", "x"},
+	}
+	for _, pc := range providerColumns {
+		if !db.Migrator().HasColumn(&model.User{}, pc.column) {
+			continue
+		}
+		err = db.Exec(`INSERT INTO oauth_identities (user_id, provider, subject, email, created_at)
+			SELECT id, ?, `+pc.column+`, email, NOW() FROM users
+			WHERE `+pc.column+` IS NOT NULL AND `+pc.column+` <> ''
+			ON CONFLICT DO NOTHING`, pc.provider).Error
+		if err != nil {
+			return err
+		}
+		err = db.Migrator().DropColumn(&model.User{}, pc.column)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Migration 13: Create roles and permissions tables
+	log.Println("Running migration: Create roles and permissions tables")
+	err = db.AutoMigrate(&model.Permission{}, &model.Role{}, &model.UserRole{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 14: Seed default roles and convert IsAdmin users to the admin role
+	log.Println("Running migration: Seed default roles")
+	err = rbac.Seed(db)
+	if err != nil {
+		return err
+	}
+	if db.Migrator().HasColumn(&model.User{}, "is_admin") {
+		err = db.Exec(`INSERT INTO user_roles (user_id, role_id, created_at)
+			SELECT users.id, roles.id, NOW() FROM users, roles
+			WHERE users.is_admin AND roles.name = ?
+			ON CONFLICT DO NOTHING`, rbac.RoleAdmin).Error
+		if err != nil {
+			return err
+		}
+		err = db.Migrator().DropColumn(&model.User{}, "is_admin")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Migration 15: Create organization, membership and invitation tables
+	log.Println("Running migration: Create organization tables")
+	err = db.AutoMigrate(&model.Organization{}, &model.Membership{}, &model.OrganizationInvitation{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 16: Create personal access tokens table
+	log.Println("Running migration: Create personal access tokens table")
+	err = db.AutoMigrate(&model.PersonalAccessToken{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 17: Make audit events append-only. Rows can still be deleted
+	// by the audit-prune command, but never modified.
+	log.Println("Running migration: Make audit events append-only")
+	err = db.Exec(`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql`).Error
+	if err != nil {
+		return err
+	}
+	err = db.Exec("DROP TRIGGER IF EXISTS audit_events_no_update ON audit_events").Error
+	if err != nil {
+		return err
+	}
+	err = db.Exec(`CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`).Error
+	if err != nil {
+		return err
+	}
+
+	// Migration 18: Create runtime settings table
+	log.Println("Running migration: Create settings table")
+	err = db.AutoMigrate(&model.Setting{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 19: Create uploads table
+	log.Println("Running migration: Create uploads table")
+	err = db.AutoMigrate(&model.Upload{})
+	if err != nil {
+		return err
+	}
+
+	// Migration 20: Add any additional indexes or constraints
 	log.Println("Running migration: Add additional indexes and constraints")
 
 	// Example: Add a composite index if needed
@@ -49,7 +222,7 @@ func runMigrations() error {
 	// 	return err
 	// }
 
-	// Migration 3: Seed initial data if needed
+	// Migration 21: Seed initial data if needed
 	log.Println("Running migration: Seed initial data")
 
 	// Create admin user if it doesn't exist
@@ -63,30 +236,29 @@ func runMigrations() error {
 			if err != nil {
 				return err
 			}
+			now := time.Now()
 			adminUser = model.User{
-				Username:    "admin",
-				Email:       "admin@example.com",
-				Password:    string(hashedPassword),
-				Name:        "Administrator",
-				LoginMethod: "password",
-				IsAdmin:     true,
+				Username:        "admin",
+				Email:           "admin@example.com",
+				Password:        string(hashedPassword),
+				Name:            "Administrator",
+				LoginMethod:     "password",
+				EmailVerifiedAt: &now,
 			}
 			err = db.Create(&adminUser).Error
 			if err != nil {
 				return err
 			}
+			err = rbac.Assign(db, adminUser.ID, rbac.RoleAdmin)
+			if err != nil {
+				return err
+			}
 			log.Println("Admin user created successfully")
 		} else {
 			return result.Error
 		}
 	} else {
 		log.Println("Admin user already exists")
-		// Update existing admin user to ensure IsAdmin is set
-		if !adminUser.IsAdmin {
-			adminUser.IsAdmin = true
-			db.Save(&adminUser)
-			log.Println("Updated existing admin user to set IsAdmin flag")
-		}
 	}
 
 	return nil
@@ -3,45 +3,102 @@ package middleware
 import (
 	"net/http"
 
-	"github.com/dariubs/scaffold/app/model"
-	"github.com/gin-contrib/sessions"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/impersonation"
+	"github.com/dariubs/scaffold/app/rbac"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-// RequireAdmin checks if the user is authenticated and is an admin
+// RequireAdmin checks if the user is authenticated and may open the admin panel
 func RequireAdmin(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		userID := session.Get("user_id")
-
-		if userID == nil {
+		// Check if user exists and holds the admin panel permission
+		user, ok := sessionUser(c, db)
+		if !ok {
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
 		}
-
-		// Check if user exists and is admin
-		var user model.User
-		if err := db.First(&user, userID).Error; err != nil {
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+		if user.Suspended() {
+			rejectSuspended(c, user)
 			return
 		}
 
-		if !user.IsAdmin {
+		// An impersonated session acts as the user, never as the admin
+		// behind it; the admin must stop impersonating first
+		if impersonation.Active(c) {
 			c.HTML(http.StatusForbidden, "error.html", gin.H{
-				"Title": "Forbidden",
-				"Error": "You do not have permission to access this page",
+				"Title":        "Forbidden",
+				"Error":        "Stop impersonating to return to the admin panel.",
+				"Impersonator": c.MustGet(impersonation.ContextImpersonator),
 			})
 			c.Abort()
 			return
 		}
 
-		// Store user in context for use in handlers
+		perms, err := rbac.Permissions(db, user.ID)
+		if err != nil || !perms.Has(rbac.PermAdminAccess) {
+			forbidden(c)
+			return
+		}
+
+		// Admins must enroll in two-factor authentication when it is required
+		if config.C.Auth.RequireAdmin2FA && !user.TwoFactorEnabled() {
+			c.Redirect(http.StatusFound, "/profile/2fa?required=1")
+			c.Abort()
+			return
+		}
+
+		// Store user and permissions in context for use in handlers
 		c.Set("user", user)
-		c.Set("user_id", userID)
+		c.Set("user_id", user.ID)
+		c.Set("permissions", perms)
 
 		c.Next()
 	}
 }
+
+// RequirePermission checks that the user holds perm. Inside the admin group
+// it reuses the permissions loaded by RequireAdmin; elsewhere it loads the
+// session user itself.
+func RequirePermission(db *gorm.DB, perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms, ok := c.Get("permissions")
+		if !ok {
+			user, ok := sessionUser(c, db)
+			if !ok {
+				c.Redirect(http.StatusFound, "/login")
+				c.Abort()
+				return
+			}
+			if user.Suspended() {
+				rejectSuspended(c, user)
+				return
+			}
+			set, err := rbac.Permissions(db, user.ID)
+			if err != nil {
+				forbidden(c)
+				return
+			}
+			c.Set("user", user)
+			c.Set("user_id", user.ID)
+			c.Set("permissions", set)
+			perms = set
+		}
+
+		if !perms.(rbac.Set).Has(perm) {
+			forbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+func forbidden(c *gin.Context) {
+	c.HTML(http.StatusForbidden, "error.html", gin.H{
+		"Title": "Forbidden",
+		"Error": "You do not have permission to access this page",
+	})
+	c.Abort()
+}
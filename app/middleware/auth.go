@@ -2,37 +2,146 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/dariubs/scaffold/app/apitoken"
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/impersonation"
 	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/utils"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-// RequireAuth checks if the user is authenticated
-func RequireAuth(db *gorm.DB) gin.HandlerFunc {
+// sessionUser loads the user referenced by the session. Sessions started
+// before the user's last password change are cleared and rejected. When an
+// admin is impersonating the user, the admin is stored on the context as
+// impersonation.ContextImpersonator.
+func sessionUser(c *gin.Context, db *gorm.DB) (model.User, bool) {
+	var user model.User
+	session := sessions.Default(c)
+	userID := session.Get("user_id")
+	if userID == nil {
+		return user, false
+	}
+
+	if err := db.First(&user, userID).Error; err != nil {
+		return user, false
+	}
+
+	if user.PasswordChangedAt != nil {
+		authTime, _ := session.Get("auth_time").(int64)
+		if authTime < user.PasswordChangedAt.Unix() {
+			session.Clear()
+			session.Save()
+			return user, false
+		}
+	}
+
+	admin, err := impersonation.Impersonator(c, db)
+	if err != nil {
+		session.Clear()
+		session.Save()
+		return user, false
+	}
+	if admin != nil {
+		c.Set(impersonation.ContextImpersonator, *admin)
+	}
+
+	return user, true
+}
+
+// RequireAuth checks if the user is authenticated. Routes that list scopes
+// also accept a personal access token holding all of them in an
+// "Authorization: Bearer" header; other routes only accept sessions.
+func RequireAuth(db *gorm.DB, scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		userID := session.Get("user_id")
+		if token, ok := bearerToken(c); ok {
+			tokenAuth(c, db, token, scopes)
+			return
+		}
 
-		if userID == nil {
+		// Check if user exists
+		user, ok := sessionUser(c, db)
+		if !ok {
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
 		}
 
-		// Check if user exists
-		var user model.User
-		if err := db.First(&user, userID).Error; err != nil {
-			c.Redirect(http.StatusFound, "/login")
+		if user.Suspended() {
+			rejectSuspended(c, user)
+			return
+		}
+
+		// Password accounts must confirm their email when verification is required
+		if config.C.Auth.RequireEmailVerification && user.Password != "" && !user.EmailVerified() {
+			c.Redirect(http.StatusFound, "/verify-email")
 			c.Abort()
 			return
 		}
 
 		// Store user in context for use in handlers
 		c.Set("user", user)
-		c.Set("user_id", userID)
+		c.Set("user_id", user.ID)
 
 		c.Next()
 	}
 }
+
+// rejectSuspended signs a suspended user out and shows them why, rather than
+// sending them back to a login they cannot complete.
+func rejectSuspended(c *gin.Context, user model.User) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Save()
+	c.HTML(http.StatusForbidden, "suspended.html", gin.H{
+		"Title":   "Account suspended",
+		"Account": user,
+	})
+	c.Abort()
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// tokenAuth authenticates a request by personal access token. Token requests
+// get JSON errors instead of login redirects.
+func tokenAuth(c *gin.Context, db *gorm.DB, token string, scopes []string) {
+	if len(scopes) == 0 {
+		abortJSON(c, utils.NewAppError(http.StatusUnauthorized, "This endpoint does not accept access tokens", nil))
+		return
+	}
+
+	user, record, err := apitoken.Authenticate(db, token)
+	if err != nil {
+		abortJSON(c, utils.NewAppError(http.StatusUnauthorized, "Invalid or expired access token", err))
+		return
+	}
+	for _, scope := range scopes {
+		if !record.HasScope(scope) {
+			abortJSON(c, utils.NewAppError(http.StatusForbidden, "Access token is missing the "+scope+" scope", nil))
+			return
+		}
+	}
+	if user.Suspended() {
+		abortJSON(c, utils.NewAppError(http.StatusForbidden, "Account suspended", nil))
+		return
+	}
+	if config.C.Auth.RequireEmailVerification && user.Password != "" && !user.EmailVerified() {
+		abortJSON(c, utils.NewAppError(http.StatusForbidden, "Email address not verified", nil))
+		return
+	}
+
+	c.Set("user", user)
+	c.Set("user_id", user.ID)
+	c.Set("access_token", record)
+	c.Next()
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// CSRFField is the form field, and CSRFHeader the header, that carry the
+// token on state-changing requests.
+const (
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRF protects session-authenticated forms. It keeps a random token in the
+// session, exposes it to handlers as "csrf_token" and rejects POST, PUT,
+// PATCH and DELETE requests that do not echo it back. Requests that
+// RequireAuth authenticated with an access token are let through: browsers
+// never send one on their own.
+func CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("access_token"); ok {
+			c.Next()
+			return
+		}
+		session := sessions.Default(c)
+		token, _ := session.Get("csrf_token").(string)
+		if token == "" {
+			var err error
+			token, _, err = utils.NewToken()
+			if err != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			session.Set("csrf_token", token)
+			session.Save()
+		}
+		c.Set("csrf_token", token)
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		sent := c.GetHeader(CSRFHeader)
+		if sent == "" {
+			sent = c.PostForm(CSRFField)
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			c.HTML(http.StatusForbidden, "error.html", gin.H{
+				"Title": "Forbidden",
+				"Error": "Your session expired or the form was submitted from another site. Go back, reload the page and try again.",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
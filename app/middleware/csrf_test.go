@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+func csrfRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	config.C = &config.Config{} // NewToken signs with the session secret
+	r := gin.New()
+	r.SetHTMLTemplate(template.Must(template.New("error.html").Parse("{{.Error}}")))
+	r.Use(sessions.Sessions("test", cookie.NewStore([]byte("secret"))))
+	r.GET("/form", CSRF(), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("csrf_token")) })
+	r.POST("/form", CSRF(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	r := csrfRouter()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	token := w.Body.String()
+	if token == "" {
+		t.Fatal("GET did not expose a token")
+	}
+	cookies := w.Result().Cookies()
+
+	tests := []struct {
+		name   string
+		form   string
+		header string
+		cookie bool
+		want   int
+	}{
+		{"form field", token, "", true, http.StatusNoContent},
+		{"header", "", token, true, http.StatusNoContent},
+		{"missing", "", "", true, http.StatusForbidden},
+		{"wrong", "x" + token, "", true, http.StatusForbidden},
+		{"other session", token, "", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set(CSRFField, tt.form)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.cookie {
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFAccessToken(t *testing.T) {
+	r := csrfRouter()
+	r.POST("/upload", func(c *gin.Context) { c.Set("access_token", true) }, CSRF(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d for an access token request", w.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/impersonation"
+	"github.com/gin-gonic/gin"
+)
+
+// ForbidImpersonation rejects requests made while an admin is impersonating
+// the user. Use it after RequireAuth on routes that change how the user signs
+// in, so an impersonation cannot leave credentials behind.
+func ForbidImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if impersonation.Active(c) {
+			c.HTML(http.StatusForbidden, "error.html", gin.H{
+				"Title":        "Not available while impersonating",
+				"Error":        "Sign-in settings cannot be changed while impersonating a user.",
+				"Impersonator": c.MustGet(impersonation.ContextImpersonator),
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
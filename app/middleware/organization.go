@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/tenant"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ResolveOrganization puts the user's current organization and membership on
+// the context next to user. The current organization is kept in the session
+// under "org_id"; when it is unset or the user is no longer a member, the
+// user's first organization is used. Users without an organization pass
+// through with none set. It must run after RequireAuth.
+func ResolveOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(model.User)
+		session := sessions.Default(c)
+
+		var membership model.Membership
+		var err error
+		orgID, _ := session.Get("org_id").(uint)
+		if orgID != 0 {
+			membership, err = tenant.Membership(db, orgID, user.ID)
+		}
+		if orgID == 0 || err != nil {
+			memberships, _ := tenant.Memberships(db, user.ID)
+			if len(memberships) == 0 {
+				if orgID != 0 {
+					session.Delete("org_id")
+					session.Save()
+				}
+				c.Next()
+				return
+			}
+			membership = memberships[0]
+			session.Set("org_id", membership.OrganizationID)
+			session.Save()
+		}
+
+		c.Set(tenant.ContextOrganization, membership.Organization)
+		c.Set(tenant.ContextMembership, membership)
+		c.Next()
+	}
+}
+
+// RequireOrganizationRole checks that the user holds at least role in the
+// current organization. It must run after ResolveOrganization.
+func RequireOrganizationRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, membership, ok := tenant.Current(c)
+		if !ok || !tenant.AtLeast(membership.Role, role) {
+			forbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
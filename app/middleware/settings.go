@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/settings"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireSetting responds 404, as if the route did not exist, while the
+// boolean setting key is off. Routes for optional features are registered
+// unconditionally behind it so the setting can change without a restart.
+func RequireSetting(key settings.BoolKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !settings.Bool(key) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminPath serves routes mounted under the ":admin" parameter only when it
+// matches the admin path setting, so the panel moves as soon as the setting
+// changes.
+func AdminPath() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("admin") != settings.String(settings.AdminPath) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,6 +1,10 @@
 package model
 
 import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,18 +12,359 @@ import (
 
 type User struct {
 	gorm.Model
-	Username    string `gorm:"uniqueIndex;not null"`
-	Email       string `gorm:"uniqueIndex;not null"`
-	Password    string // Can be empty for OAuth users
-	Name        string
-	AvatarURL   string
-	Bio         string
-	GoogleID    string `gorm:"uniqueIndex"`        // Google OAuth ID
-	GitHubID    string `gorm:"uniqueIndex"`        // GitHub OAuth ID
-	LinkedInID  string `gorm:"uniqueIndex"`        // LinkedIn OAuth ID
-	XID         string `gorm:"uniqueIndex"`        // X (Twitter) OAuth ID
-	LoginMethod string `gorm:"default:'password'"` // 'password', 'google', 'github', 'linkedin', 'x'
-	IsAdmin     bool   `gorm:"default:false"`      // Admin flag
+	Username          string `gorm:"uniqueIndex;not null"`
+	Email             string `gorm:"uniqueIndex;not null"`
+	Password          string // Can be empty for OAuth users
+	Name              string
+	AvatarURL         string
+	AvatarVariants    string // Sizes of the resized copies of an uploaded avatar, e.g. "64,256,1024"
+	Bio               string
+	LoginMethod       string     `gorm:"default:'password'"` // Sign-up method: 'password', 'google', 'github', 'linkedin', 'x', 'email'
+	EmailVerifiedAt   *time.Time // Nil until the email address is confirmed
+	PasswordChangedAt *time.Time // Sessions started before this are rejected
+	TOTPSecret        string     `json:"-"` // AES-GCM encrypted TOTP secret
+	TOTPEnabledAt     *time.Time // Nil unless two-factor authentication is on
+	TOTPLastStep      int64      `json:"-"` // Last accepted TOTP time step, to block code reuse
+	FailedLogins      int        // Consecutive failed password attempts
+	LastFailedLoginAt *time.Time
+	LockedUntil       *time.Time // Password login is refused until this time
+	SuspendedAt       *time.Time // Set while an admin has suspended the account
+	SuspendedUntil    *time.Time // End of the suspension; nil bans the user until it is lifted
+	SuspensionReason  string     // Shown to the user on the suspension page
+	SuspendedByID     *uint      // Admin who applied the suspension
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// EmailVerified reports whether the user has confirmed their email address.
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// TwoFactorEnabled reports whether the user must pass a TOTP check at login.
+func (u User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil && u.TOTPSecret != ""
+}
+
+// Locked reports whether password login is temporarily locked.
+func (u User) Locked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// Suspended reports whether the account is suspended right now.
+func (u User) Suspended() bool {
+	return u.SuspendedAt != nil && (u.SuspendedUntil == nil || time.Now().Before(*u.SuspendedUntil))
+}
+
+// AvatarURLFor returns the URL of the smallest copy of the avatar that is at
+// least size pixels wide, or of the largest copy when none is. Avatars without
+// copies, such as those from OAuth providers, return AvatarURL.
+func (u User) AvatarURLFor(size int) string {
+	sizes := ParseSizes(u.AvatarVariants)
+	if u.AvatarURL == "" || len(sizes) == 0 {
+		return u.AvatarURL
+	}
+	best := sizes[len(sizes)-1]
+	for _, s := range sizes {
+		if s >= size {
+			best = s
+			break
+		}
+	}
+	return VariantPath(u.AvatarURL, best)
+}
+
+// AvatarURLs returns the URL of each resized copy of the avatar by size, or
+// nil if it has none.
+func (u User) AvatarURLs() map[int]string {
+	sizes := ParseSizes(u.AvatarVariants)
+	if u.AvatarURL == "" || len(sizes) == 0 {
+		return nil
+	}
+	urls := make(map[int]string, len(sizes))
+	for _, s := range sizes {
+		urls[s] = VariantPath(u.AvatarURL, s)
+	}
+	return urls
+}
+
+// EmailVerificationToken is a single-use token emailed to confirm an address.
+// Only the signed hash of the token is stored.
+type EmailVerificationToken struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// PasswordResetToken is a single-use token emailed to reset a forgotten
+// password. Only the signed hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// RecoveryCode is a one-time code that can stand in for a TOTP code. Only the
+// signed hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"uniqueIndex;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// PasskeyCredential is a WebAuthn public key credential registered by a user.
+// It can be used for passwordless login or as a second factor.
+type PasskeyCredential struct {
+	ID              uint   `gorm:"primarykey"`
+	UserID          uint   `gorm:"index;not null"`
+	Name            string // User-chosen label, e.g. "MacBook Touch ID"
+	CredentialID    []byte `gorm:"uniqueIndex;not null"`
+	PublicKey       []byte `gorm:"not null"`
+	AttestationType string
+	Transports      string // Comma-separated authenticator transports
+	AAGUID          []byte
+	SignCount       uint32
+	Flags           uint8 // Raw authenticator flags (UV, BE, BS, ...)
+	LastUsedAt      *time.Time
+	CreatedAt       time.Time
+}
+
+// MagicLinkToken is a short-lived, single-use sign-in link sent by email. It
+// is keyed by address because the account may not exist yet. Only the signed
+// hash of the token is stored.
+type MagicLinkToken struct {
+	ID        uint   `gorm:"primarykey"`
+	Email     string `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Session is a server-side login session. The cookie only carries the signed
+// opaque ID; values live in Data so sessions can be listed and revoked.
+type Session struct {
+	ID         string `gorm:"primarykey;size:64"`
+	UserID     *uint  `gorm:"index"` // Nil for anonymous sessions
+	Data       []byte
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+// LoginAttempt records a password login attempt for per-IP throttling.
+type LoginAttempt struct {
+	ID        uint   `gorm:"primarykey"`
+	Username  string // As submitted
+	UserID    *uint  `gorm:"index"`
+	IP        string `gorm:"index"`
+	Success   bool
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// AccountUnlockToken is a single-use token emailed when an account is locked
+// so the owner can unlock it. Only the signed hash of the token is stored.
+type AccountUnlockToken struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// OAuthIdentity links an account at an external OAuth provider to a user. A
+// user may connect several providers, but each provider account belongs to
+// exactly one user.
+type OAuthIdentity struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_oauth_identities_user_provider"`
+	Provider  string `gorm:"not null;uniqueIndex:idx_oauth_identities_user_provider;uniqueIndex:idx_oauth_identities_provider_subject"` // e.g. "google"
+	Subject   string `gorm:"not null;uniqueIndex:idx_oauth_identities_provider_subject"`                                                // Provider's user ID
+	Email     string // Email reported by the provider, if any
+	CreatedAt time.Time
+}
+
+// AuditEvent is an append-only record of a security-relevant action.
+type AuditEvent struct {
+	ID        uint   `gorm:"primarykey"`
+	Action    string `gorm:"index;not null"` // e.g. "account.locked"
+	ActorID   *uint  `gorm:"index"`          // User who performed the action, if any
+	TargetID  *uint  `gorm:"index"`          // User the action applies to, if any
+	IP        string
+	UserAgent string
+	Metadata  string    `gorm:"type:jsonb;default:'{}'"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// Role is a named set of permissions. Users are granted permissions by being
+// assigned roles.
+type Role struct {
+	ID          uint   `gorm:"primarykey"`
+	Name        string `gorm:"uniqueIndex;not null"` // e.g. "moderator"
+	Description string
+	Permissions []Permission `gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Permission is a single capability checked by the application, such as
+// "users.edit".
+type Permission struct {
+	ID          uint   `gorm:"primarykey"`
+	Name        string `gorm:"uniqueIndex;not null"`
+	Description string
+	CreatedAt   time.Time
+}
+
+// UserRole assigns a role to a user.
+type UserRole struct {
+	UserID    uint `gorm:"primaryKey"`
+	RoleID    uint `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// Organization is a multi-tenant workspace. Users belong to organizations
+// through memberships.
+type Organization struct {
+	ID          uint   `gorm:"primarykey"`
+	Name        string `gorm:"not null"`
+	CreatedByID uint   `gorm:"index"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+// Membership gives a user a role in an organization.
+type Membership struct {
+	ID             uint         `gorm:"primarykey"`
+	OrganizationID uint         `gorm:"not null;uniqueIndex:idx_memberships_organization_user"`
+	Organization   Organization `gorm:"constraint:OnDelete:CASCADE"`
+	UserID         uint         `gorm:"not null;index;uniqueIndex:idx_memberships_organization_user"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE"`
+	Role           string       `gorm:"not null;default:'member'"` // 'owner', 'admin' or 'member'
+	CreatedAt      time.Time
+}
+
+// OrganizationInvitation is an emailed invitation to join an organization.
+// Only the signed hash of the token is stored.
+type OrganizationInvitation struct {
+	ID             uint         `gorm:"primarykey"`
+	OrganizationID uint         `gorm:"index;not null"`
+	Organization   Organization `gorm:"constraint:OnDelete:CASCADE"`
+	Email          string       `gorm:"index;not null"`
+	Role           string       `gorm:"not null"`
+	InvitedByID    uint
+	TokenHash      string `gorm:"uniqueIndex;not null"`
+	ExpiresAt      time.Time
+	AcceptedAt     *time.Time
+	DeclinedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+// Pending reports whether the invitation can still be accepted or declined.
+func (i OrganizationInvitation) Pending() bool {
+	return i.AcceptedAt == nil && i.DeclinedAt == nil && time.Now().Before(i.ExpiresAt)
+}
+
+// PersonalAccessToken is a named API credential sent as a bearer token. Only
+// the signed hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primarykey"`
+	UserID     uint       `gorm:"index;not null"`
+	Name       string     `gorm:"not null"`
+	TokenHash  string     `gorm:"uniqueIndex;not null"`
+	Hint       string     // First characters of the token, to tell tokens apart
+	Scopes     string     // Comma-separated, e.g. "profile:read,uploads"
+	ExpiresAt  *time.Time // Nil for tokens that never expire
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// ScopeList returns the token's scopes.
+func (t PersonalAccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// HasScope reports whether the token was granted scope.
+func (t PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token can no longer be used.
+func (t PersonalAccessToken) Expired() bool {
+	return t.ExpiresAt != nil && !time.Now().Before(*t.ExpiresAt)
+}
+
+// Setting is a runtime setting changed from the admin panel. Settings without
+// a row use their default, which usually comes from the environment.
+type Setting struct {
+	Key         string `gorm:"primaryKey"`
+	Value       string `gorm:"not null"`
+	UpdatedByID *uint
+	UpdatedAt   time.Time
+}
+
+// Upload is a file stored through app/storage. Every stored file has a row,
+// which records who owns it.
+type Upload struct {
+	ID          uint   `gorm:"primarykey"`
+	UserID      uint   `gorm:"index;not null"`       // Owner
+	Key         string `gorm:"uniqueIndex;not null"` // Storage key, e.g. "general/general-<uuid>.png"
+	Folder      string `gorm:"index;not null"`
+	Size        int64  // Bytes
+	ContentType string
+	Checksum    string // Hex-encoded SHA-256 of the contents
+	Width       int    // Pixel dimensions of images; 0 for other files
+	Height      int
+	Variants    string    // Sizes of the resized copies of an image, e.g. "64,256,1024"
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// VariantSizes returns the sizes of the upload's resized copies, smallest
+// first.
+func (u Upload) VariantSizes() []int {
+	return ParseSizes(u.Variants)
+}
+
+// VariantKey returns the storage key of the copy that fits in a size by size
+// box.
+func (u Upload) VariantKey(size int) string {
+	return VariantPath(u.Key, size)
+}
+
+// VariantPath returns the key or URL of a resized copy of the file at p, which
+// is stored next to it with the size before the extension: "a/b.jpg" becomes
+// "a/b_64.jpg".
+func VariantPath(p string, size int) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "_" + strconv.Itoa(size) + ext
+}
+
+// ParseSizes parses a comma-separated list of sizes, ignoring invalid
+// entries, and returns them in ascending order.
+func ParseSizes(s string) []int {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && n > 0 {
+			sizes = append(sizes, n)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes
+}
@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/config"
+)
+
+// githubAPI answers requests to api.github.com with fixed JSON bodies keyed
+// by path.
+type githubAPI map[string]string
+
+func (api githubAPI) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	if body, ok := api[r.URL.Path]; ok && r.URL.Host == "api.github.com" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteString(body)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	return w.Result(), nil
+}
+
+func TestGitHubEmail(t *testing.T) {
+	const user = `{"id": 7, "login": "octocat", "name": "Octo Cat", "email": ""}`
+	tests := []struct {
+		name   string
+		user   string
+		emails string
+		want   string
+	}{
+		{"public email", `{"id": 7, "login": "octocat", "email": "public@example.com"}`, "", "public@example.com"},
+		{"primary verified", user, `[{"email": "other@example.com", "verified": true}, {"email": "main@example.com", "primary": true, "verified": true}]`, "main@example.com"},
+		{"primary unverified", user, `[{"email": "main@example.com", "primary": true}, {"email": "other@example.com", "verified": true}]`, "octocat@github.user"},
+		{"no primary", user, `[{"email": "other@example.com", "verified": true}]`, "octocat@github.user"},
+		{"emails unavailable", user, "", "octocat@github.user"},
+	}
+	p := newGitHub(config.OAuthProviderConfig{}).(*Basic)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := githubAPI{"/user": tt.user}
+			if tt.emails != "" {
+				api["/user/emails"] = tt.emails
+			}
+			id, err := p.Profile(context.Background(), &http.Client{Transport: api})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Email != tt.want || id.Subject != "7" || id.Username != "octocat" {
+				t.Errorf("Profile() = %+v, want email %q", id, tt.want)
+			}
+		})
+	}
+}
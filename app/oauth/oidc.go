@@ -0,0 +1,159 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/dariubs/scaffold/app/config"
+	"golang.org/x/oauth2"
+)
+
+// Generic OpenID Connect provider for IdPs such as Keycloak, Authentik or
+// Okta. Endpoints and signing keys come from the issuer's discovery document,
+// ID tokens are verified against its JWKS, and claims are mapped to user
+// fields by configuration.
+
+const oidcIcon = `<svg class="w-5 h-5" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2"><path stroke-linecap="round" stroke-linejoin="round" d="M12 15v2m-6 4h12a2 2 0 002-2v-6a2 2 0 00-2-2H6a2 2 0 00-2 2v6a2 2 0 002 2zm10-10V7a4 4 0 00-8 0v4h8z"/></svg>`
+
+func init() {
+	Register(Definition{
+		Name:  "oidc",
+		Order: 50,
+		New:   newOIDC,
+		Ready: func() bool { return config.C.OIDC.IssuerURL != "" },
+	})
+}
+
+var (
+	oidcMu         sync.Mutex
+	oidcDiscovered *gooidc.Provider
+)
+
+// discoverOIDC fetches and caches the issuer's discovery document. A failed
+// discovery is retried on the next login.
+func discoverOIDC() (*gooidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcDiscovered != nil {
+		return oidcDiscovered, nil
+	}
+
+	// Not the request context: the provider keeps it for later JWKS refreshes
+	ctx := gooidc.ClientContext(context.Background(), &http.Client{Timeout: 10 * time.Second})
+	p, err := gooidc.NewProvider(ctx, config.C.OIDC.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	oidcDiscovered = p
+	return p, nil
+}
+
+type oidcProvider struct {
+	cfg config.OAuthProviderConfig
+}
+
+func newOIDC(cfg config.OAuthProviderConfig) Provider {
+	return &oidcProvider{cfg: cfg}
+}
+
+func (p *oidcProvider) Name() string        { return "oidc" }
+func (p *oidcProvider) Label() string       { return config.C.OIDC.Label }
+func (p *oidcProvider) Icon() template.HTML { return oidcIcon }
+
+// oauth2Config builds the client configuration from the discovered endpoints.
+func (p *oidcProvider) oauth2Config() (*gooidc.Provider, oauth2.Config, error) {
+	discovered, err := discoverOIDC()
+	if err != nil {
+		return nil, oauth2.Config{}, err
+	}
+	return discovered, oauth2Config(p.cfg, discovered.Endpoint(), config.C.OIDC.Scopes...), nil
+}
+
+func (p *oidcProvider) AuthCodeURL(_ context.Context, flow Flow) (string, error) {
+	_, cfg, err := p.oauth2Config()
+	if err != nil {
+		return "", err
+	}
+	return cfg.AuthCodeURL(flow.State, gooidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier)), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code string, flow Flow) (*oauth2.Token, error) {
+	_, cfg, err := p.oauth2Config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+}
+
+func (p *oidcProvider) Identity(ctx context.Context, token *oauth2.Token, flow Flow) (Identity, error) {
+	discovered, _, err := p.oauth2Config()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return Identity{}, errors.New("oidc: token response has no id_token")
+	}
+	idToken, err := discovered.Verifier(&gooidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc: %w", err)
+	}
+	if flow.Nonce == "" || idToken.Nonce != flow.Nonce {
+		return Identity{}, errors.New("oidc: nonce mismatch")
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+	// Some IdPs only put profile claims in the userinfo response
+	if userInfo, err := discovered.UserInfo(ctx, oauth2.StaticTokenSource(token)); err == nil {
+		extra := map[string]interface{}{}
+		if userInfo.Claims(&extra) == nil {
+			for k, v := range extra {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	mapping := config.C.OIDC.Claims
+	id := Identity{
+		Subject:   idToken.Subject,
+		Email:     claimString(claims, mapping.Email),
+		Username:  claimString(claims, mapping.Username),
+		Name:      claimString(claims, mapping.Name),
+		AvatarURL: claimString(claims, mapping.AvatarURL),
+	}
+	if id.Email == "" {
+		id.Email = idToken.Subject + "@oidc.user"
+	}
+	if id.Username == "" {
+		id.Username = id.Email
+	}
+	return id, nil
+}
+
+// claimString returns the claim called name as a string, or "" when it is
+// missing or name is empty.
+func claimString(claims map[string]interface{}, name string) string {
+	if name == "" {
+		return ""
+	}
+	switch v := claims[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,256 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dariubs/scaffold/app/config"
+)
+
+const testClientID = "scaffold"
+
+// testIdP is a minimal OpenID provider: discovery, JWKS, token and userinfo
+// endpoints. The token endpoint returns idToken.
+type testIdP struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	idToken  string
+	userInfo map[string]interface{}
+	verifier string // code_verifier of the last token request
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	idp := &testIdP{key: testRSAKey(t)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"userinfo_endpoint":                     idp.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := idp.key.PublicKey
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.verifier = r.PostForm.Get("code_verifier")
+		writeJSON(w, map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, idp.userInfo)
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// signJWT returns claims as an RS256 JWT signed with key.
+func signJWT(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// setupOIDC points the oidc provider at idp and returns it.
+func setupOIDC(t *testing.T, idp *testIdP) Provider {
+	t.Helper()
+	config.C = &config.Config{}
+	config.C.OIDC.IssuerURL = idp.URL
+	config.C.OIDC.Scopes = []string{"openid", "profile", "email"}
+	config.C.OIDC.Claims.Email = "email"
+	config.C.OIDC.Claims.Username = "preferred_username"
+	config.C.OIDC.Claims.Name = "name"
+	config.C.OIDC.Claims.AvatarURL = "picture"
+	oidcMu.Lock()
+	oidcDiscovered = nil
+	oidcMu.Unlock()
+	return newOIDC(config.OAuthProviderConfig{ClientID: testClientID, ClientSecret: "secret", RedirectURL: "http://localhost/auth/oidc/callback"})
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	idp := newTestIdP(t)
+	p := setupOIDC(t, idp)
+	flow := Flow{State: "state", Verifier: "verifier", Nonce: "nonce"}
+
+	raw, err := p.AuthCodeURL(context.Background(), flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != idp.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %q, want the discovered one", got)
+	}
+	q := u.Query()
+	for param, want := range map[string]string{
+		"client_id":             testClientID,
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"scope":                 "openid profile email",
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+	if q.Get("code_challenge") == "" {
+		t.Error("code_challenge is missing")
+	}
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	idp := newTestIdP(t)
+	p := setupOIDC(t, idp)
+	other := testRSAKey(t)
+
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":                idp.URL,
+			"aud":                testClientID,
+			"sub":                "user-1",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              "nonce",
+			"email":              "alice@example.com",
+			"preferred_username": "alice",
+			"name":               "Alice",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		claims  map[string]interface{}
+		nonce   string
+		want    Identity
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			claims: claims(nil),
+			nonce:  "nonce",
+			want:   Identity{Subject: "user-1", Email: "alice@example.com", Username: "alice", Name: "Alice", AvatarURL: "https://idp.example.com/alice.png"},
+		},
+		{
+			name:   "missing email",
+			claims: claims(func(c map[string]interface{}) { delete(c, "email"); delete(c, "preferred_username") }),
+			nonce:  "nonce",
+			want:   Identity{Subject: "user-1", Email: "user-1@oidc.user", Username: "user-1@oidc.user", Name: "Alice", AvatarURL: "https://idp.example.com/alice.png"},
+		},
+		{name: "bad nonce", claims: claims(nil), nonce: "other", wantErr: "nonce"},
+		{name: "no nonce in flow", claims: claims(func(c map[string]interface{}) { delete(c, "nonce") }), wantErr: "nonce"},
+		{name: "bad signature", key: other, claims: claims(nil), nonce: "nonce", wantErr: "signature"},
+		{name: "wrong audience", claims: claims(func(c map[string]interface{}) { c["aud"] = "someone-else" }), nonce: "nonce", wantErr: "audience"},
+		{name: "wrong issuer", claims: claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }), nonce: "nonce", wantErr: "different provider"},
+		{name: "expired", claims: claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), nonce: "nonce", wantErr: "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			if key == nil {
+				key = idp.key
+			}
+			idp.idToken = signJWT(t, key, tt.claims)
+			idp.userInfo = map[string]interface{}{"sub": "user-1", "picture": "https://idp.example.com/alice.png", "name": "Not Alice"}
+			flow := Flow{State: "state", Verifier: "verifier", Nonce: tt.nonce}
+
+			ctx := context.Background()
+			token, err := p.Exchange(ctx, "code", flow)
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if idp.verifier != "verifier" {
+				t.Errorf("code_verifier = %q, want the flow's verifier", idp.verifier)
+			}
+			got, err := p.Identity(ctx, token, flow)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Identity() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Identity: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Identity() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimString(t *testing.T) {
+	claims := map[string]interface{}{"s": "x", "n": float64(42), "b": true}
+	tests := map[string]string{"s": "x", "n": "42", "b": "true", "missing": "", "": ""}
+	for name, want := range tests {
+		if got := claimString(claims, name); got != want {
+			t.Errorf("claimString(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package oauth holds the registry of OAuth login providers. Each provider
+// registers itself from an init function and is enabled by configuration, so
+// adding one needs no changes to the user model, routes or templates.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/settings"
+	"golang.org/x/oauth2"
+)
+
+// Identity is the provider account a login resolved to.
+type Identity struct {
+	Subject   string // Provider's stable user ID
+	Email     string // May be a placeholder when the provider shares none
+	Username  string
+	Name      string
+	AvatarURL string
+}
+
+// Flow is the per-login state kept in the session between the redirect to
+// the provider and the callback.
+type Flow struct {
+	State    string // CSRF token echoed back by the provider
+	Verifier string // PKCE code verifier
+	Nonce    string // OpenID Connect nonce
+}
+
+// NewFlow returns a Flow with fresh random values.
+func NewFlow() (Flow, error) {
+	var f Flow
+	for _, v := range []*string{&f.State, &f.Verifier, &f.Nonce} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Flow{}, err
+		}
+		*v = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return f, nil
+}
+
+// Provider is an OAuth login provider.
+type Provider interface {
+	// Name is the route and identity key, e.g. "github".
+	Name() string
+	// Label is the display name, e.g. "GitHub".
+	Label() string
+	// Icon is inline SVG markup shown on login buttons.
+	Icon() template.HTML
+	// AuthCodeURL returns the provider URL that starts a login.
+	AuthCodeURL(ctx context.Context, flow Flow) (string, error)
+	// Exchange trades the callback code for a token.
+	Exchange(ctx context.Context, code string, flow Flow) (*oauth2.Token, error)
+	// Identity fetches the account behind token.
+	Identity(ctx context.Context, token *oauth2.Token, flow Flow) (Identity, error)
+}
+
+// Definition describes a provider that can be enabled by configuration.
+type Definition struct {
+	Name           string
+	EnabledDefault bool // Applies when LOGIN_<NAME>_ENABLED is unset
+	Order          int  // Position on the login page; lower comes first
+	New            func(cfg config.OAuthProviderConfig) Provider
+	// Ready optionally reports whether provider-specific settings beyond the
+	// client credentials are present.
+	Ready func() bool
+}
+
+// settings returns the provider's configuration and whether it can be used.
+// Whether the provider is on comes from its runtime setting.
+func (def Definition) settings() (config.OAuthProviderConfig, bool) {
+	cfg := config.C.OAuthProvider(def.Name, def.EnabledDefault)
+	cfg.Enabled = settings.Bool(settings.OAuthEnabled(def.Name))
+	if !cfg.Configured() || (def.Ready != nil && !def.Ready()) {
+		return cfg, false
+	}
+	return cfg, true
+}
+
+var (
+	mu          sync.Mutex
+	definitions = map[string]Definition{}
+)
+
+// Register adds a provider definition and the setting that turns it on. It
+// is meant to be called from init.
+func Register(def Definition) {
+	mu.Lock()
+	definitions[def.Name] = def
+	mu.Unlock()
+
+	prefix := strings.ToUpper(def.Name)
+	settings.Register(settings.Definition{
+		Key:         string(settings.OAuthEnabled(def.Name)),
+		Type:        settings.TypeBool,
+		Label:       "OAuth login: " + def.Name,
+		Description: "Also requires " + prefix + "_CLIENT_ID and " + prefix + "_CLIENT_SECRET. Defaults to LOGIN_" + prefix + "_ENABLED.",
+		Default: func() string {
+			return strconv.FormatBool(config.C.OAuthProvider(def.Name, def.EnabledDefault).Enabled)
+		},
+	})
+}
+
+// Enabled returns the providers that are turned on and configured, in login
+// page order.
+func Enabled() []Provider {
+	mu.Lock()
+	defs := make([]Definition, 0, len(definitions))
+	for _, def := range definitions {
+		defs = append(defs, def)
+	}
+	mu.Unlock()
+
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].Order != defs[j].Order {
+			return defs[i].Order < defs[j].Order
+		}
+		return defs[i].Name < defs[j].Name
+	})
+
+	var providers []Provider
+	for _, def := range defs {
+		if cfg, ok := def.settings(); ok {
+			providers = append(providers, def.New(cfg))
+		}
+	}
+	return providers
+}
+
+// Get returns the enabled provider called name.
+func Get(name string) (Provider, bool) {
+	mu.Lock()
+	def, ok := definitions[name]
+	mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	cfg, ok := def.settings()
+	if !ok {
+		return nil, false
+	}
+	return def.New(cfg), true
+}
+
+// Basic implements Provider for plain OAuth 2.0 providers: the authorization
+// code flow, optionally with PKCE, followed by a profile fetch.
+type Basic struct {
+	ProviderName  string
+	ProviderLabel string
+	ProviderIcon  template.HTML
+	Config        oauth2.Config
+	PKCE          bool
+	// Profile maps the provider's user API response to an Identity. client
+	// sends requests authorized with the login's token.
+	Profile func(ctx context.Context, client *http.Client) (Identity, error)
+}
+
+func (p *Basic) Name() string        { return p.ProviderName }
+func (p *Basic) Label() string       { return p.ProviderLabel }
+func (p *Basic) Icon() template.HTML { return p.ProviderIcon }
+
+func (p *Basic) AuthCodeURL(_ context.Context, flow Flow) (string, error) {
+	if p.PKCE {
+		return p.Config.AuthCodeURL(flow.State, oauth2.S256ChallengeOption(flow.Verifier)), nil
+	}
+	return p.Config.AuthCodeURL(flow.State), nil
+}
+
+func (p *Basic) Exchange(ctx context.Context, code string, flow Flow) (*oauth2.Token, error) {
+	if p.PKCE {
+		return p.Config.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	}
+	return p.Config.Exchange(ctx, code)
+}
+
+func (p *Basic) Identity(ctx context.Context, token *oauth2.Token, _ Flow) (Identity, error) {
+	return p.Profile(ctx, p.Config.Client(ctx, token))
+}
+
+// oauth2Config builds the oauth2.Config for a provider from its settings.
+func oauth2Config(cfg config.OAuthProviderConfig, endpoint oauth2.Endpoint, scopes ...string) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       scopes,
+		Endpoint:     endpoint,
+	}
+}
+
+// getJSON fetches url with client and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
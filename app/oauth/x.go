@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/dariubs/scaffold/app/config"
+	"golang.org/x/oauth2"
+)
+
+const xIcon = `<svg class="w-5 h-5" viewBox="0 0 24 24" fill="currentColor"><path d="M18.244 2.25h3.308l-7.227 8.26 8.502 11.24H16.17l-5.214-6.817L4.99 21.75H1.68l7.73-8.835L1.254 2.25H8.08l4.713 6.231zm-1.161 17.52h1.833L7.084 4.126H5.117z"/></svg>`
+
+// X (Twitter) OAuth 2.0 requires PKCE and client credentials in the
+// Authorization header.
+var xEndpoint = oauth2.Endpoint{
+	AuthURL:   "https://twitter.com/i/oauth2/authorize",
+	TokenURL:  "https://api.twitter.com/2/oauth2/token",
+	AuthStyle: oauth2.AuthStyleInHeader,
+}
+
+func init() {
+	Register(Definition{Name: "x", Order: 40, New: newX})
+}
+
+type xUserData struct {
+	Data struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"data"`
+}
+
+func newX(cfg config.OAuthProviderConfig) Provider {
+	return &Basic{
+		ProviderName:  "x",
+		ProviderLabel: "X",
+		ProviderIcon:  xIcon,
+		Config:        oauth2Config(cfg, xEndpoint, "users.read", "tweet.read"),
+		PKCE:          true,
+		Profile: func(ctx context.Context, client *http.Client) (Identity, error) {
+			var xu xUserData
+			if err := getJSON(ctx, client, "https://api.twitter.com/2/users/me?user.fields=profile_image_url", &xu); err != nil {
+				return Identity{}, err
+			}
+			if xu.Data.ID == "" {
+				return Identity{}, errors.New("x: empty user id")
+			}
+			return Identity{
+				Subject:  xu.Data.ID,
+				Email:    xu.Data.Username + "@x.user", // X does not share email addresses
+				Username: xu.Data.Username,
+				Name:     xu.Data.Name,
+			}, nil
+		},
+	}
+}
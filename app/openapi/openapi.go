@@ -0,0 +1,303 @@
+// Package openapi builds an OpenAPI 3 document from operations described next
+// to the handlers that serve them. Request and response schemas are derived
+// from Go types by reflection, following their json tags.
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Param is a query, path or multipart form parameter.
+type Param struct {
+	Name        string
+	Description string
+	Type        string // "string" (default), "integer", "boolean" or "file"
+	Required    bool
+}
+
+// Operation describes one route.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Session     bool     // Accepts the session cookie
+	Scopes      []string // Accepts a personal access token with these scopes
+	Permission  string   // RBAC permission the caller must hold, if any
+	Query       []Param
+	Body        interface{} // JSON request body, e.g. api.UpdateProfileRequest{}
+	Form        []Param     // multipart/form-data fields
+	Response    interface{} // JSON response body; nil when there is none
+	Status      int         // Success status, http.StatusOK by default
+	Errors      []int       // Error statuses, described by utils.ErrorResponse
+}
+
+type route struct {
+	method string
+	path   string
+}
+
+// Spec collects operations and renders them as an OpenAPI document.
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+	operations  map[route]Operation
+}
+
+// New returns an empty spec.
+func New(title, version, description string) *Spec {
+	return &Spec{
+		Title:       title,
+		Version:     version,
+		Description: description,
+		operations:  map[route]Operation{},
+	}
+}
+
+// Add describes the route registered with gin as method and path, e.g.
+// ("GET", "/api/v1/users/:id").
+func (s *Spec) Add(method, path string, op Operation) {
+	s.operations[route{method, path}] = op
+}
+
+// Undocumented returns the routes whose path starts with one of prefixes but
+// that have no operation, formatted as "METHOD /path".
+func (s *Spec) Undocumented(routes gin.RoutesInfo, prefixes ...string) []string {
+	var missing []string
+	for _, r := range routes {
+		if !hasPrefix(r.Path, prefixes) {
+			continue
+		}
+		if _, ok := s.operations[route{r.Method, r.Path}]; !ok {
+			missing = append(missing, r.Method+" "+r.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Unregistered returns the operations that describe no registered route,
+// formatted as "METHOD /path".
+func (s *Spec) Unregistered(routes gin.RoutesInfo) []string {
+	registered := map[route]bool{}
+	for _, r := range routes {
+		registered[route{r.Method, r.Path}] = true
+	}
+	var stale []string
+	for r := range s.operations {
+		if !registered[r] {
+			stale = append(stale, r.method+" "+r.path)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if path == p || strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var pathParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// Document renders the OpenAPI 3 document.
+func (s *Spec) Document() map[string]interface{} {
+	schemas := newSchemaSet()
+	errorSchema := schemas.of(utils.ErrorResponse{})
+
+	paths := map[string]map[string]interface{}{}
+	for r, op := range s.operations {
+		path := pathParam.ReplaceAllString(r.path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+
+		var params []interface{}
+		for _, m := range pathParam.FindAllStringSubmatch(r.path, -1) {
+			typ := "string"
+			if m[1] == "id" || strings.HasSuffix(m[1], "_id") {
+				typ = "integer"
+			}
+			params = append(params, map[string]interface{}{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": typ},
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": q.Name, "in": "query", "required": q.Required,
+				"description": q.Description, "schema": paramSchema(q),
+			})
+		}
+
+		out := map[string]interface{}{
+			"summary":     op.Summary,
+			"operationId": operationID(r),
+		}
+		if op.Description != "" || op.Permission != "" || len(op.Scopes) > 0 {
+			out["description"] = describe(op)
+		}
+		if len(op.Tags) > 0 {
+			out["tags"] = op.Tags
+		}
+		if len(params) > 0 {
+			out["parameters"] = params
+		}
+		if body := requestBody(op, schemas); body != nil {
+			out["requestBody"] = body
+		}
+		if security := security(op); security != nil {
+			out["security"] = security
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		responses := map[string]interface{}{}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.Response != nil {
+			success["content"] = jsonContent(schemas.of(op.Response))
+		}
+		responses[strconv.Itoa(status)] = success
+		for _, code := range op.Errors {
+			responses[strconv.Itoa(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content":     jsonContent(errorSchema),
+			}
+		}
+		out["responses"] = responses
+
+		paths[path][strings.ToLower(r.method)] = out
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       s.Title,
+			"version":     s.Version,
+			"description": s.Description,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal access token created on the profile page",
+				},
+				"sessionCookie": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "scaffoldsession",
+				},
+			},
+		},
+	}
+	return doc
+}
+
+// Handler serves the document as JSON.
+func (s *Spec) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.Document())
+	}
+}
+
+func describe(op Operation) string {
+	parts := []string{}
+	if op.Description != "" {
+		parts = append(parts, op.Description)
+	}
+	if len(op.Scopes) > 0 {
+		parts = append(parts, "Token scopes: `"+strings.Join(op.Scopes, "`, `")+"`.")
+	}
+	if op.Permission != "" {
+		parts = append(parts, "Requires the `"+op.Permission+"` permission.")
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func security(op Operation) []interface{} {
+	var out []interface{}
+	if len(op.Scopes) > 0 {
+		out = append(out, map[string]interface{}{"bearerAuth": []string{}})
+	}
+	if op.Session {
+		out = append(out, map[string]interface{}{"sessionCookie": []string{}})
+	}
+	return out
+}
+
+func requestBody(op Operation, schemas *schemaSet) map[string]interface{} {
+	switch {
+	case op.Body != nil:
+		return map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(schemas.of(op.Body)),
+		}
+	case len(op.Form) > 0:
+		props := map[string]interface{}{}
+		var required []string
+		for _, f := range op.Form {
+			props[f.Name] = paramSchema(f)
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{"schema": schema},
+			},
+		}
+	}
+	return nil
+}
+
+func paramSchema(p Param) map[string]interface{} {
+	schema := map[string]interface{}{"type": "string"}
+	switch p.Type {
+	case "integer", "boolean":
+		schema["type"] = p.Type
+	case "file":
+		schema["format"] = "binary"
+	}
+	if p.Description != "" {
+		schema["description"] = p.Description
+	}
+	return schema
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// operationID derives a stable ID such as "getApiV1UsersId".
+func operationID(r route) string {
+	id := strings.ToLower(r.method)
+	for _, part := range strings.FieldsFunc(r.path, func(c rune) bool {
+		return c == '/' || c == ':' || c == '*' || c == '-' || c == '_'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
@@ -0,0 +1,223 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func routes(paths ...string) gin.RoutesInfo {
+	var out gin.RoutesInfo
+	for i := 0; i < len(paths); i += 2 {
+		out = append(out, gin.RouteInfo{Method: paths[i], Path: paths[i+1]})
+	}
+	return out
+}
+
+func TestUndocumented(t *testing.T) {
+	spec := New("t", "1", "")
+	spec.Add(http.MethodGet, "/api/v1/me", Operation{})
+	spec.Add(http.MethodGet, "/health", Operation{})
+
+	tests := []struct {
+		name     string
+		routes   gin.RoutesInfo
+		prefixes []string
+		want     []string
+	}{
+		{"all documented", routes("GET", "/api/v1/me", "GET", "/health"), []string{"/api/", "/health"}, nil},
+		{"missing route", routes("GET", "/api/v1/me", "PATCH", "/api/v1/me"), []string{"/api/"}, []string{"PATCH /api/v1/me"}},
+		{"outside prefixes", routes("GET", "/profile", "POST", "/login"), []string{"/api/"}, nil},
+		{"exact prefix", routes("GET", "/readiness"), []string{"/readiness"}, []string{"GET /readiness"}},
+		{"sorted", routes("POST", "/api/b", "GET", "/api/a"), []string{"/api/"}, []string{"GET /api/a", "POST /api/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spec.Undocumented(tt.routes, tt.prefixes...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Undocumented() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnregistered(t *testing.T) {
+	spec := New("t", "1", "")
+	spec.Add(http.MethodGet, "/api/v1/me", Operation{})
+	spec.Add(http.MethodDelete, "/api/v1/uploads", Operation{})
+
+	got := spec.Unregistered(routes("GET", "/api/v1/me", "POST", "/api/v1/uploads"))
+	want := []string{"DELETE /api/v1/uploads"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unregistered() = %v, want %v", got, want)
+	}
+	if got := spec.Unregistered(routes("GET", "/api/v1/me", "DELETE", "/api/v1/uploads")); got != nil {
+		t.Errorf("Unregistered() = %v, want none", got)
+	}
+}
+
+func TestOperationID(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/api/v1/users/:id", "getApiV1UsersId"},
+		{"POST", "/upload/profile-image", "postUploadProfileImage"},
+		{"DELETE", "/api/v1/users/:id/sessions", "deleteApiV1UsersIdSessions"},
+		{"GET", "/files/*path", "getFilesPath"},
+	}
+	for _, tt := range tests {
+		if got := operationID(route{tt.method, tt.path}); got != tt.want {
+			t.Errorf("operationID(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+type testItem struct {
+	Data interface{} `json:"data"`
+}
+
+type testUser struct {
+	ID        uint       `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Roles     []string   `json:"roles"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	Secret    string     `json:"-"`
+	internal  string
+}
+
+// roundTrip returns the document as decoded JSON so tests compare what
+// clients see.
+func roundTrip(t *testing.T, spec *Spec) map[string]interface{} {
+	t.Helper()
+	b, err := json.Marshal(spec.Document())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// get walks doc along keys.
+func get(t *testing.T, doc interface{}, keys ...string) interface{} {
+	t.Helper()
+	for _, k := range keys {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%q: not an object", k)
+		}
+		if doc, ok = m[k]; !ok {
+			t.Fatalf("missing %q", k)
+		}
+	}
+	return doc
+}
+
+func TestDocument(t *testing.T) {
+	spec := New("Test API", "1.0.0", "")
+	spec.Add(http.MethodGet, "/api/v1/users/:id", Operation{
+		Summary:    "Get a user",
+		Scopes:     []string{"admin"},
+		Permission: "users.view",
+		Query:      []Param{{Name: "verbose", Type: "boolean"}},
+		Response:   testItem{Data: testUser{}},
+		Errors:     []int{http.StatusNotFound},
+	})
+	spec.Add(http.MethodPost, "/upload/image", Operation{
+		Summary: "Upload",
+		Session: true,
+		Form:    []Param{{Name: "image", Type: "file", Required: true}},
+		Status:  http.StatusCreated,
+	})
+	doc := roundTrip(t, spec)
+
+	if got := get(t, doc, "openapi"); got != "3.0.3" {
+		t.Errorf("openapi = %v", got)
+	}
+	op := get(t, doc, "paths", "/api/v1/users/{id}", "get").(map[string]interface{})
+	if op["operationId"] != "getApiV1UsersId" {
+		t.Errorf("operationId = %v", op["operationId"])
+	}
+	if op["description"] != "Token scopes: `admin`.\n\nRequires the `users.view` permission." {
+		t.Errorf("description = %q", op["description"])
+	}
+	params := op["parameters"].([]interface{})
+	if len(params) != 2 {
+		t.Fatalf("got %d parameters, want 2", len(params))
+	}
+	if p := params[0].(map[string]interface{}); p["in"] != "path" || get(t, p, "schema", "type") != "integer" {
+		t.Errorf("path parameter = %v", p)
+	}
+	if p := params[1].(map[string]interface{}); p["in"] != "query" || get(t, p, "schema", "type") != "boolean" {
+		t.Errorf("query parameter = %v", p)
+	}
+	if got := get(t, op, "responses", "404", "content", "application/json", "schema", "$ref"); got != "#/components/schemas/ErrorResponse" {
+		t.Errorf("404 schema = %v", got)
+	}
+	data := get(t, op, "responses", "200", "content", "application/json", "schema", "properties", "data", "$ref")
+	if data != "#/components/schemas/testUser" {
+		t.Errorf("envelope data schema = %v", data)
+	}
+	security := op["security"].([]interface{})
+	if len(security) != 1 || get(t, security[0], "bearerAuth") == nil {
+		t.Errorf("security = %v", security)
+	}
+
+	upload := get(t, doc, "paths", "/upload/image", "post").(map[string]interface{})
+	if _, ok := get(t, upload, "responses").(map[string]interface{})["201"]; !ok {
+		t.Errorf("responses = %v, want 201", upload["responses"])
+	}
+	form := get(t, upload, "requestBody", "content", "multipart/form-data", "schema")
+	if got := get(t, form, "properties", "image", "format"); got != "binary" {
+		t.Errorf("image field format = %v", got)
+	}
+	if got := get(t, form, "required"); !reflect.DeepEqual(got, []interface{}{"image"}) {
+		t.Errorf("required = %v", got)
+	}
+	if get(t, upload, "security").([]interface{})[0].(map[string]interface{})["sessionCookie"] == nil {
+		t.Errorf("upload security = %v", upload["security"])
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schemas := newSchemaSet()
+	if got := schemas.of(testUser{}); got["$ref"] != "#/components/schemas/testUser" {
+		t.Fatalf("schema = %v", got)
+	}
+	user := schemas.components["testUser"].(map[string]interface{})
+	props := user["properties"].(map[string]interface{})
+
+	want := map[string]map[string]interface{}{
+		"id":         {"type": "integer"},
+		"name":       {"type": "string"},
+		"roles":      {"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"deleted_at": {"type": "string", "format": "date-time"},
+		"created_at": {"type": "string", "format": "date-time"},
+	}
+	if len(props) != len(want) {
+		t.Errorf("properties = %v, want %d of them", props, len(want))
+	}
+	for name, schema := range want {
+		if !reflect.DeepEqual(props[name], schema) {
+			t.Errorf("%s = %v, want %v", name, props[name], schema)
+		}
+	}
+	// omitempty and pointer fields are optional
+	if got := user["required"]; !reflect.DeepEqual(got, []string{"id", "roles", "created_at"}) {
+		t.Errorf("required = %v", got)
+	}
+
+	if got := schemas.of([]byte("x")); !reflect.DeepEqual(got, map[string]interface{}{"type": "string", "format": "byte"}) {
+		t.Errorf("[]byte schema = %v", got)
+	}
+	if got := schemas.of(map[string]int{}); !reflect.DeepEqual(got, map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "integer"}}) {
+		t.Errorf("map schema = %v", got)
+	}
+}
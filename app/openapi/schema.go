@@ -0,0 +1,163 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaSet converts Go values to JSON schemas, collecting named structs as
+// reusable components.
+type schemaSet struct {
+	components map[string]interface{}
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{components: map[string]interface{}{}}
+}
+
+// of returns the schema for v. Interface fields are described by the value
+// they hold, so envelopes such as api.Item{Data: api.User{}} document their
+// payload.
+func (s *schemaSet) of(v interface{}) map[string]interface{} {
+	return s.value(reflect.ValueOf(v))
+}
+
+func (s *schemaSet) value(v reflect.Value) map[string]interface{} {
+	if !v.IsValid() {
+		return map[string]interface{}{}
+	}
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return map[string]interface{}{}
+		}
+		return s.value(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return s.typ(t.Elem())
+		}
+		return s.value(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		items := s.typ(t.Elem())
+		if v.Len() > 0 {
+			items = s.value(v.Index(0))
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case reflect.Struct:
+		if t == timeType || !hasInterface(t) {
+			return s.typ(t)
+		}
+		return s.object(t, v)
+	}
+	return s.typ(t)
+}
+
+// typ returns the schema for a type with no value to inspect.
+func (s *schemaSet) typ(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.typ(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.typ(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.typ(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return s.object(t, reflect.Value{})
+		}
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = map[string]interface{}{} // Guards recursive types
+			s.components[t.Name()] = s.object(t, reflect.Value{})
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object describes a struct's exported fields. v may be invalid when only
+// the type is known.
+func (s *schemaSet) object(t reflect.Type, v reflect.Value) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, omitempty, skip := jsonName(f)
+		if skip {
+			continue
+		}
+
+		var schema map[string]interface{}
+		if v.IsValid() {
+			schema = s.value(v.Field(i))
+		} else {
+			schema = s.typ(f.Type)
+		}
+		props[name] = schema
+
+		if !omitempty && f.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+
+	out := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// jsonName returns the JSON name of f and whether it is omitted when empty
+// or always skipped.
+func jsonName(f reflect.StructField) (name string, omitempty, skip bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty, false
+}
+
+// hasInterface reports whether t has an interface field, whose schema
+// depends on the value it holds.
+func hasInterface(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Interface {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+// Package rbac implements role-based access control: permissions are granted
+// to roles, and roles are assigned to users.
+package rbac
+
+import (
+	"errors"
+	"html/template"
+	"time"
+
+	"github.com/dariubs/scaffold/app/model"
+	"gorm.io/gorm"
+)
+
+// Permissions checked by the application.
+const (
+	PermAdminAccess    = "admin.access"      // Open the admin panel
+	PermUsersView      = "users.view"        // List and view users
+	PermUsersEdit      = "users.edit"        // Edit, lock and unlock users
+	PermSessionsRevoke = "sessions.revoke"   // Sign users out everywhere
+	PermRolesManage    = "roles.manage"      // Assign and remove roles
+	PermAuditView      = "audit.view"        // Read the audit log
+	PermImpersonate    = "users.impersonate" // Sign in as another user
+	PermSettingsManage = "settings.manage"   // Change runtime settings
+	PermUploadsManage  = "uploads.manage"    // List and delete any user's files
+)
+
+// AllPermissions lists every permission with its description.
+var AllPermissions = []model.Permission{
+	{Name: PermAdminAccess, Description: "Open the admin panel"},
+	{Name: PermUsersView, Description: "List and view users"},
+	{Name: PermUsersEdit, Description: "Edit, lock and unlock users"},
+	{Name: PermSessionsRevoke, Description: "Sign users out of all sessions"},
+	{Name: PermRolesManage, Description: "Assign and remove roles"},
+	{Name: PermAuditView, Description: "Read the audit log"},
+	{Name: PermImpersonate, Description: "Sign in as a non-admin user to see what they see"},
+	{Name: PermSettingsManage, Description: "Change runtime settings such as login methods"},
+	{Name: PermUploadsManage, Description: "List and delete any user's uploaded files"},
+}
+
+// RoleAdmin is the built-in role that always holds every permission.
+const RoleAdmin = "admin"
+
+// defaultRole is a role created by Seed.
+type defaultRole struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+var defaultRoles = []defaultRole{
+	{RoleAdmin, "Full access to the admin panel", nil},
+	{"moderator", "Manage user accounts", []string{PermAdminAccess, PermUsersView, PermUsersEdit, PermSessionsRevoke, PermUploadsManage}},
+	{"support", "Help users with their accounts", []string{PermAdminAccess, PermUsersView, PermSessionsRevoke, PermImpersonate}},
+	{"auditor", "Read-only access to users and the audit log", []string{PermAdminAccess, PermUsersView, PermAuditView}},
+}
+
+// Set is the set of permissions held by a user.
+type Set map[string]bool
+
+// Has reports whether the set contains perm.
+func (s Set) Has(perm string) bool {
+	return s[perm]
+}
+
+// FuncMap returns the template helpers for permission checks:
+// {{if can .Permissions "users.edit"}}.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"can": func(s Set, perm string) bool { return s.Has(perm) },
+	}
+}
+
+// Permissions returns every permission granted to userID through its roles.
+func Permissions(db *gorm.DB, userID uint) (Set, error) {
+	var names []string
+	err := db.Model(&model.Permission{}).
+		Distinct("permissions.name").
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+		Where("user_roles.user_id = ?", userID).
+		Pluck("permissions.name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+	set := make(Set, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set, nil
+}
+
+// Can reports whether userID holds perm. Lookup errors deny access.
+func Can(db *gorm.DB, userID uint, perm string) bool {
+	set, err := Permissions(db, userID)
+	return err == nil && set.Has(perm)
+}
+
+// UserRoles returns the roles assigned to userID.
+func UserRoles(db *gorm.DB, userID uint) ([]model.Role, error) {
+	var roles []model.Role
+	err := db.Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").Find(&roles).Error
+	return roles, err
+}
+
+// Assign gives userID the named role. Assigning a role twice is a no-op.
+func Assign(db *gorm.DB, userID uint, roleName string) error {
+	var role model.Role
+	if err := db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+	return db.Where(model.UserRole{UserID: userID, RoleID: role.ID}).FirstOrCreate(&model.UserRole{}).Error
+}
+
+// ErrLastAdmin is returned when removing the admin role from, or deleting,
+// its only active holder.
+var ErrLastAdmin = errors.New("rbac: cannot remove the last administrator")
+
+// IsAdmin reports whether userID holds the admin role.
+func IsAdmin(db *gorm.DB, userID uint) bool {
+	var n int64
+	db.Model(&model.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND roles.name = ?", userID, RoleAdmin).
+		Count(&n)
+	return n > 0
+}
+
+// CanManage reports whether actorID may edit, suspend, delete or reset the
+// password of targetID. Users with admin panel access can only be managed by
+// administrators and by users holding roles.manage, so that a moderator
+// cannot take over or lock out an account with more access than their own.
+func CanManage(db *gorm.DB, actorID, targetID uint) bool {
+	if !Can(db, targetID, PermAdminAccess) {
+		return true
+	}
+	return IsAdmin(db, actorID) || Can(db, actorID, PermRolesManage)
+}
+
+// RequireOtherAdmin returns ErrLastAdmin when userID is the only user who is
+// neither deleted nor suspended and holds the admin role.
+func RequireOtherAdmin(db *gorm.DB, userID uint) error {
+	if !IsAdmin(db, userID) {
+		return nil
+	}
+	var others int64
+	db.Model(&model.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL").
+		Where("(users.suspended_at IS NULL OR users.suspended_until <= ?)", time.Now()).
+		Where("roles.name = ? AND user_roles.user_id <> ?", RoleAdmin, userID).
+		Count(&others)
+	if others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// Unassign removes the named role from userID. The admin role cannot be
+// removed from the last user holding it.
+func Unassign(db *gorm.DB, userID uint, roleName string) error {
+	var role model.Role
+	if err := db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if role.Name == RoleAdmin {
+			if err := RequireOtherAdmin(tx, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&model.UserRole{}).Error
+	})
+}
+
+// Seed creates the permissions and default roles. A default role is granted
+// its permissions when it is created, and an existing one only gains the
+// permissions created by this run, i.e. those added by a newer release.
+// Grants an admin removed from an existing role therefore stay removed. The
+// admin role is always granted every permission.
+func Seed(db *gorm.DB) error {
+	byName := map[string]model.Permission{}
+	created := map[string]bool{}
+	for _, p := range AllPermissions {
+		var perm model.Permission
+		err := db.Where("name = ?", p.Name).First(&perm).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			perm = p
+			err = db.Create(&perm).Error
+			created[perm.Name] = true
+		case err == nil && perm.Description != p.Description:
+			err = db.Model(&perm).Update("description", p.Description).Error
+		}
+		if err != nil {
+			return err
+		}
+		byName[perm.Name] = perm
+	}
+
+	for _, def := range defaultRoles {
+		var role model.Role
+		result := db.Where(model.Role{Name: def.Name}).Attrs(model.Role{Description: def.Description}).FirstOrCreate(&role)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var perms []model.Permission
+		switch {
+		case def.Name == RoleAdmin:
+			for _, p := range AllPermissions {
+				perms = append(perms, byName[p.Name])
+			}
+		case result.RowsAffected > 0:
+			for _, name := range def.Permissions {
+				perms = append(perms, byName[name])
+			}
+		default:
+			for _, name := range def.Permissions {
+				if created[name] {
+					perms = append(perms, byName[name])
+				}
+			}
+		}
+		if len(perms) == 0 {
+			continue
+		}
+		if err := db.Model(&role).Association("Permissions").Append(perms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package rbac
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/database/dbtest"
+	"github.com/dariubs/scaffold/app/model"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := dbtest.Open(t, &model.User{}, &model.Permission{}, &model.Role{}, &model.UserRole{})
+	if err := Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func rolePermissions(t *testing.T, db *gorm.DB, name string) []string {
+	t.Helper()
+	var role model.Role
+	if err := db.Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range role.Permissions {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sorted(names ...string) []string {
+	sort.Strings(names)
+	return names
+}
+
+func TestSeed(t *testing.T) {
+	db := testDB(t)
+	if got := rolePermissions(t, db, RoleAdmin); len(got) != len(AllPermissions) {
+		t.Errorf("admin permissions = %v, want all %d", got, len(AllPermissions))
+	}
+	for _, def := range defaultRoles[1:] {
+		if got := rolePermissions(t, db, def.Name); !reflect.DeepEqual(got, sorted(def.Permissions...)) {
+			t.Errorf("%s permissions = %v, want %v", def.Name, got, sorted(def.Permissions...))
+		}
+	}
+
+	// A grant an admin removed stays removed on the next run
+	var support model.Role
+	db.Where("name = ?", "support").First(&support)
+	var impersonate model.Permission
+	db.Where("name = ?", PermImpersonate).First(&impersonate)
+	if err := db.Model(&support).Association("Permissions").Delete(&impersonate); err != nil {
+		t.Fatal(err)
+	}
+	if err := Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range rolePermissions(t, db, "support") {
+		if name == PermImpersonate {
+			t.Error("Seed restored a revoked permission")
+		}
+	}
+
+	// A permission added by a newer release is granted to existing roles
+	db.Exec("DELETE FROM role_permissions WHERE permission_id = ?", impersonate.ID)
+	db.Delete(&impersonate)
+	if err := Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rolePermissions(t, db, "support"), sorted(defaultRoles[2].Permissions...)) {
+		t.Errorf("support permissions = %v after a new permission", rolePermissions(t, db, "support"))
+	}
+
+	var roles, perms int64
+	db.Model(&model.Role{}).Count(&roles)
+	db.Model(&model.Permission{}).Count(&perms)
+	if roles != int64(len(defaultRoles)) || perms != int64(len(AllPermissions)) {
+		t.Errorf("after reseeding: %d roles, %d permissions", roles, perms)
+	}
+}
+
+func createUser(t *testing.T, db *gorm.DB, name string, roles ...string) uint {
+	t.Helper()
+	user := model.User{Username: name, Email: name + "@example.com"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range roles {
+		if err := Assign(db, user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user.ID
+}
+
+func TestAssign(t *testing.T) {
+	db := testDB(t)
+	id := createUser(t, db, "alice")
+
+	if Can(db, id, PermUsersEdit) {
+		t.Fatal("a user without roles can edit users")
+	}
+	for i := 0; i < 2; i++ {
+		if err := Assign(db, id, "moderator"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roles, err := UserRoles(db, id)
+	if err != nil || len(roles) != 1 || roles[0].Name != "moderator" {
+		t.Fatalf("UserRoles() = %v, %v, want moderator once", roles, err)
+	}
+	if !Can(db, id, PermUsersEdit) || Can(db, id, PermRolesManage) || IsAdmin(db, id) {
+		t.Error("moderator permissions are wrong")
+	}
+	if err := Assign(db, id, "no-such-role"); err == nil {
+		t.Error("Assign() accepted an unknown role")
+	}
+	if err := Unassign(db, id, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	if Can(db, id, PermUsersEdit) {
+		t.Error("permission kept after Unassign")
+	}
+}
+
+func TestUnassignLastAdmin(t *testing.T) {
+	db := testDB(t)
+	admin := createUser(t, db, "admin", RoleAdmin)
+	if err := Unassign(db, admin, RoleAdmin); err != ErrLastAdmin {
+		t.Fatalf("Unassign(last admin) = %v, want ErrLastAdmin", err)
+	}
+	other := createUser(t, db, "other", RoleAdmin)
+	if err := Unassign(db, admin, RoleAdmin); err != nil {
+		t.Fatalf("Unassign() = %v with another admin", err)
+	}
+	if !IsAdmin(db, other) || IsAdmin(db, admin) {
+		t.Error("admin role not moved")
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	db := testDB(t)
+	admin := createUser(t, db, "admin", RoleAdmin)
+	moderator := createUser(t, db, "moderator", "moderator")
+	support := createUser(t, db, "support", "support")
+	member := createUser(t, db, "member")
+
+	tests := []struct {
+		actor, target uint
+		want          bool
+	}{
+		{admin, moderator, true},
+		{admin, member, true},
+		{moderator, member, true},
+		{moderator, support, false},
+		{moderator, admin, false},
+		{support, moderator, false},
+		{member, member, true},
+	}
+	for _, tt := range tests {
+		if got := CanManage(db, tt.actor, tt.target); got != tt.want {
+			t.Errorf("CanManage(%d, %d) = %v, want %v", tt.actor, tt.target, got, tt.want)
+		}
+	}
+}
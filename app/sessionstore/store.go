@@ -0,0 +1,251 @@
+// Package sessionstore implements a Postgres-backed session store for
+// gin-contrib/sessions. The cookie holds only a signed, opaque session ID;
+// session values and metadata are kept in the sessions table so they can be
+// listed and revoked.
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dariubs/scaffold/app/model"
+	ginsessions "github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"gorm.io/gorm"
+)
+
+// lastSeenInterval throttles last-seen updates on read-only requests.
+const lastSeenInterval = time.Minute
+
+// defaultMaxAge is the session lifetime when none is configured (30 days).
+const defaultMaxAge = 86400 * 30
+
+// Store is a gin-contrib/sessions store backed by the sessions table.
+type Store struct {
+	db      *gorm.DB
+	codecs  []securecookie.Codec
+	options *sessions.Options
+}
+
+var _ ginsessions.Store = (*Store)(nil)
+
+// NewStore returns a database-backed store. keyPairs sign the session ID
+// cookie, as for cookie.NewStore.
+func NewStore(db *gorm.DB, keyPairs ...[]byte) *Store {
+	return &Store{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   defaultMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Options sets the cookie options for new sessions.
+func (s *Store) Options(options ginsessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+// Get returns a cached session for the request.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session referenced by the request cookie, or returns a new
+// empty session if there is none or it has expired or been revoked.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	var row model.Session
+	if err := s.db.First(&row, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return session, nil
+		}
+		return session, err
+	}
+	if time.Now().After(row.ExpiresAt) {
+		s.db.Delete(&row)
+		return session, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(row.Data)).Decode(&session.Values); err != nil {
+		return session, nil
+	}
+
+	session.ID = row.ID
+	session.IsNew = false
+
+	if time.Since(row.LastSeenAt) > lastSeenInterval {
+		s.db.Model(&row).Updates(map[string]interface{}{
+			"last_seen_at": time.Now(),
+			"ip":           clientIP(r),
+			"user_agent":   r.UserAgent(),
+		})
+	}
+	return session, nil
+}
+
+// Save persists the session and writes the ID cookie. A negative MaxAge
+// deletes the session. The ID is rotated whenever the logged-in user changes
+// to prevent session fixation.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.db.Delete(&model.Session{}, "id = ?", session.ID).Error; err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	userID := UserID(session.Values)
+	if session.ID != "" {
+		var existing model.Session
+		if s.db.Select("user_id").First(&existing, "id = ?", session.ID).Error == nil && !sameUser(existing.UserID, userID) {
+			s.db.Delete(&model.Session{}, "id = ?", session.ID)
+			session.ID = ""
+		}
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	}
+	row := model.Session{
+		ID:         session.ID,
+		UserID:     userID,
+		Data:       data.Bytes(),
+		UserAgent:  r.UserAgent(),
+		IP:         clientIP(r),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(maxAge) * time.Second),
+	}
+
+	if session.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		row.ID = id
+		row.CreatedAt = now
+		if err := s.db.Create(&row).Error; err != nil {
+			return err
+		}
+		session.ID = row.ID
+	} else {
+		err := s.db.Model(&model.Session{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+			"user_id":      row.UserID,
+			"data":         row.Data,
+			"user_agent":   row.UserAgent,
+			"ip":           row.IP,
+			"last_seen_at": row.LastSeenAt,
+			"expires_at":   row.ExpiresAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// UserID extracts the logged-in user ID from session values.
+func UserID(values map[interface{}]interface{}) *uint {
+	if id, ok := values["user_id"].(uint); ok {
+		return &id
+	}
+	return nil
+}
+
+// RevokeUser deletes every session belonging to userID, except the session
+// IDs listed in keep.
+func RevokeUser(db *gorm.DB, userID uint, keep ...string) error {
+	query := db.Where("user_id = ?", userID)
+	if len(keep) > 0 {
+		query = query.Where("id NOT IN ?", keep)
+	}
+	return query.Delete(&model.Session{}).Error
+}
+
+// PruneExpired deletes expired sessions and returns how many were removed.
+func PruneExpired(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at < ?", time.Now()).Delete(&model.Session{})
+	return result.RowsAffected, result.Error
+}
+
+func sameUser(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
+
+type clientIPKey struct{}
+
+// ClientIP records gin's client IP on the request, which is all the store
+// sees, so sessions get the same address as the rest of the app: proxy
+// headers only count when sent by a trusted proxy. It must run before the
+// sessions middleware.
+func ClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+		c.Next()
+	}
+}
+
+// clientIP returns the address recorded by the ClientIP middleware, or the
+// address of the connection.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
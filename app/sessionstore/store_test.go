@@ -0,0 +1,56 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		header     string
+		want       string
+	}{
+		{"direct", nil, "203.0.113.7:5000", "", "203.0.113.7"},
+		{"spoofed header", nil, "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"untrusted proxy", []string{"10.0.0.0/8"}, "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.2:5000", "198.51.100.1", "198.51.100.1"},
+		{"proxy chain", []string{"10.0.0.0/8"}, "10.0.0.2:5000", "198.51.100.1, 10.0.0.3", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			if err := r.SetTrustedProxies(tt.trusted); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			r.GET("/", ClientIP(), func(c *gin.Context) {
+				got = clientIP(c.Request)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set("X-Forwarded-For", tt.header)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := clientIP(req); got != "203.0.113.7" {
+		t.Errorf("clientIP() = %q, want the connection's address", got)
+	}
+}
@@ -0,0 +1,304 @@
+// Package settings holds settings that admins can change at runtime. Saved
+// values live in the settings table and are cached in memory; settings that
+// were never saved fall back to their default, which usually comes from the
+// environment.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Type is the type of a setting's value.
+type Type string
+
+// Setting types.
+const (
+	TypeBool   Type = "bool"
+	TypeString Type = "string"
+)
+
+// BoolKey and StringKey name settings of each type, so a setting cannot be
+// read as the wrong type.
+type (
+	BoolKey   string
+	StringKey string
+)
+
+// Settings built into the application. OAuth providers add one BoolKey each;
+// see OAuthEnabled.
+const (
+	LoginPassword  BoolKey   = "login.password_enabled"
+	LoginPasskey   BoolKey   = "login.passkey_enabled"
+	LoginMagicLink BoolKey   = "login.magic_link_enabled"
+	AdminPath      StringKey = "server.admin_path"
+)
+
+// OAuthEnabled returns the key that turns the named OAuth provider on.
+func OAuthEnabled(provider string) BoolKey {
+	return BoolKey("login." + provider + "_enabled")
+}
+
+// cacheTTL is how long values are cached. Changes made on this instance apply
+// immediately; other instances pick them up within cacheTTL.
+const cacheTTL = 30 * time.Second
+
+// ErrUnknown is returned when saving a setting that is not registered.
+var ErrUnknown = errors.New("settings: unknown setting")
+
+// Definition describes a setting shown on the admin settings page.
+type Definition struct {
+	Key         string
+	Type        Type
+	Label       string
+	Description string
+	// Default returns the value used while the setting has not been saved.
+	Default func() string
+	// Validate optionally rejects values beyond the type check.
+	Validate func(value string) error
+}
+
+var (
+	mu          sync.RWMutex
+	definitions []Definition
+	store       *gorm.DB
+	values      = map[string]string{}
+	loadedAt    time.Time
+	reserved    = map[string]bool{}
+)
+
+func init() {
+	Register(Definition{
+		Key:         string(LoginPassword),
+		Type:        TypeBool,
+		Label:       "Password login",
+		Description: "Sign in and password reset with a username and password. Defaults to LOGIN_PASSWORD_ENABLED.",
+		Default:     func() string { return strconv.FormatBool(config.C.Login.PasswordEnabled) },
+	})
+	Register(Definition{
+		Key:         string(LoginPasskey),
+		Type:        TypeBool,
+		Label:       "Passkey login",
+		Description: "Sign in with a passkey (WebAuthn). Defaults to LOGIN_PASSKEY_ENABLED.",
+		Default:     func() string { return strconv.FormatBool(config.C.Login.PasskeyEnabled) },
+	})
+	Register(Definition{
+		Key:         string(LoginMagicLink),
+		Type:        TypeBool,
+		Label:       "Magic link login",
+		Description: "Sign in with a link sent by email. Defaults to LOGIN_MAGIC_LINK_ENABLED.",
+		Default:     func() string { return strconv.FormatBool(config.C.Login.MagicLinkEnabled) },
+	})
+	Register(Definition{
+		Key:         string(AdminPath),
+		Type:        TypeString,
+		Label:       "Admin panel path",
+		Description: "First path segment of the admin panel. Defaults to ADMIN_BASE_PATH.",
+		Default:     func() string { return config.C.Server.AdminPath },
+		Validate:    validateAdminPath,
+	})
+}
+
+// Register adds a setting definition. It is meant to be called from init.
+func Register(def Definition) {
+	mu.Lock()
+	defer mu.Unlock()
+	definitions = append(definitions, def)
+}
+
+// Definitions returns the registered settings in registration order.
+func Definitions() []Definition {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Definition(nil), definitions...)
+}
+
+// Lookup returns the definition of key.
+func Lookup(key string) (Definition, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, def := range definitions {
+		if def.Key == key {
+			return def, true
+		}
+	}
+	return Definition{}, false
+}
+
+// Init loads the saved settings from db and uses db to refresh them. Until it
+// runs, every setting reads as its default.
+func Init(db *gorm.DB) error {
+	mu.Lock()
+	store = db
+	mu.Unlock()
+	return reload()
+}
+
+// reload replaces the cache with the saved settings.
+func reload() error {
+	mu.RLock()
+	db := store
+	mu.RUnlock()
+	if db == nil {
+		return nil
+	}
+
+	var rows []model.Setting
+	err := db.Find(&rows).Error
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Failed loads keep the old values and are retried after cacheTTL
+	loadedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	values = make(map[string]string, len(rows))
+	for _, row := range rows {
+		values[row.Key] = row.Value
+	}
+	return nil
+}
+
+// refresh reloads the cache, logging failures; the old values stay in use.
+func refresh() {
+	if err := reload(); err != nil {
+		utils.Logger.Error("Failed to reload settings", "err", err)
+	}
+}
+
+// Saved returns the saved value of key, if any.
+func Saved(key string) (string, bool) {
+	mu.RLock()
+	stale := store != nil && time.Since(loadedAt) > cacheTTL
+	mu.RUnlock()
+	if stale {
+		refresh()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	value, ok := values[key]
+	return value, ok
+}
+
+// Value returns the current value of key: the saved value, or the default.
+func Value(key string) string {
+	if value, ok := Saved(key); ok {
+		return value
+	}
+	if def, ok := Lookup(key); ok && def.Default != nil {
+		return def.Default()
+	}
+	return ""
+}
+
+// Bool returns the current value of a boolean setting.
+func Bool(key BoolKey) bool {
+	b, _ := strconv.ParseBool(Value(string(key)))
+	return b
+}
+
+// String returns the current value of a string setting.
+func String(key StringKey) string {
+	return Value(string(key))
+}
+
+// PasskeyEnabled reports whether passkey login is switched on and the WebAuthn
+// relying party is configured.
+func PasskeyEnabled() bool {
+	return Bool(LoginPasskey) && config.C.WebAuthnConfigured()
+}
+
+// Normalize checks value against the definition of key and returns it in
+// canonical form, e.g. "true" for a boolean "1".
+func Normalize(key, value string) (string, error) {
+	def, ok := Lookup(key)
+	if !ok {
+		return "", ErrUnknown
+	}
+	value = strings.TrimSpace(value)
+	if def.Type == TypeBool {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be true or false", def.Label)
+		}
+		value = strconv.FormatBool(b)
+	}
+	if def.Validate != nil {
+		if err := def.Validate(value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// Set validates and saves value for key on behalf of userID. The new value
+// applies on this instance at once.
+func Set(db *gorm.DB, key, value string, userID uint) error {
+	value, err := Normalize(key, value)
+	if err != nil {
+		return err
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_by_id", "updated_at"}),
+	}).Create(&model.Setting{Key: key, Value: value, UpdatedByID: &userID}).Error
+	if err != nil {
+		return err
+	}
+	refresh()
+	return nil
+}
+
+// Reset deletes the saved value of key so it falls back to its default.
+func Reset(db *gorm.DB, key string) error {
+	if _, ok := Lookup(key); !ok {
+		return ErrUnknown
+	}
+	if err := db.Where("key = ?", key).Delete(&model.Setting{}).Error; err != nil {
+		return err
+	}
+	refresh()
+	return nil
+}
+
+// ReserveRoutes records the first path segment of every route that does not
+// start with a parameter, so the admin path cannot shadow them. Call it once
+// all routes are registered.
+func ReserveRoutes(routes gin.RoutesInfo) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, route := range routes {
+		segment := strings.SplitN(strings.TrimPrefix(route.Path, "/"), "/", 2)[0]
+		if segment != "" && segment[0] != ':' && segment[0] != '*' {
+			reserved[segment] = true
+		}
+	}
+}
+
+var adminPathPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func validateAdminPath(value string) error {
+	if !adminPathPattern.MatchString(value) {
+		return errors.New("The admin panel path may only contain letters, digits, dashes and underscores")
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if reserved[value] {
+		return fmt.Errorf("/%s is already used by the application", value)
+	}
+	return nil
+}
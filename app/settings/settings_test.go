@@ -0,0 +1,133 @@
+package settings
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/dariubs/scaffold/app/database/dbtest"
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// setup resets the package state and returns an empty settings table, which
+// Init has not been called with yet.
+func setup(t *testing.T) *gorm.DB {
+	t.Helper()
+	config.C = &config.Config{}
+	config.C.Server.AdminPath = "admin"
+	mu.Lock()
+	store, values, reserved, loadedAt = nil, map[string]string{}, map[string]bool{}, time.Time{}
+	mu.Unlock()
+	return dbtest.Open(t, &model.Setting{})
+}
+
+func TestNormalize(t *testing.T) {
+	setup(t)
+	ReserveRoutes(gin.RoutesInfo{{Path: "/login"}, {Path: "/api/v1/me"}, {Path: "/:slug"}, {Path: "/"}})
+
+	tests := []struct {
+		key, value string
+		want       string
+		wantErr    bool
+	}{
+		{string(LoginPassword), "true", "true", false},
+		{string(LoginPassword), " 1 ", "true", false},
+		{string(LoginPassword), "F", "false", false},
+		{string(LoginPassword), "0", "false", false},
+		{string(LoginPassword), "yes", "", true},
+		{string(LoginPassword), "", "", true},
+		{string(AdminPath), " backoffice ", "backoffice", false},
+		{string(AdminPath), "back_office-2", "back_office-2", false},
+		{string(AdminPath), "", "", true},
+		{string(AdminPath), "a/b", "", true},
+		{string(AdminPath), "../admin", "", true},
+		{string(AdminPath), "admin panel", "", true},
+		{string(AdminPath), "login", "", true},
+		{string(AdminPath), "api", "", true},
+		{string(AdminPath), ":slug", "", true},
+		{"no.such.setting", "x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.key, tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, %v, want %q, error %v", tt.key, tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := Normalize("no.such.setting", "x"); err != ErrUnknown {
+		t.Errorf("Normalize(unknown) error = %v, want ErrUnknown", err)
+	}
+}
+
+func TestSetAndReset(t *testing.T) {
+	db := setup(t)
+	if got := String(AdminPath); got != "admin" {
+		t.Fatalf("String() before Init = %q, want the default", got)
+	}
+	if err := Init(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Set(db, string(AdminPath), "backoffice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := String(AdminPath); got != "backoffice" {
+		t.Errorf("String() after Set = %q, want backoffice", got)
+	}
+	if err := Set(db, string(AdminPath), "panel", 1); err != nil {
+		t.Fatal(err)
+	}
+	var rows int64
+	db.Model(&model.Setting{}).Count(&rows)
+	if got := String(AdminPath); got != "panel" || rows != 1 {
+		t.Errorf("String() after a second Set = %q with %d rows, want panel in 1 row", got, rows)
+	}
+	if err := Set(db, string(AdminPath), "a/b", 1); err == nil {
+		t.Error("Set() saved an invalid value")
+	}
+
+	if err := Reset(db, string(AdminPath)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := Saved(string(AdminPath)); ok {
+		t.Errorf("Saved() after Reset = %q, want none", got)
+	}
+	if got := String(AdminPath); got != "admin" {
+		t.Errorf("String() after Reset = %q, want the default", got)
+	}
+	if err := Reset(db, "no.such.setting"); err != ErrUnknown {
+		t.Errorf("Reset(unknown) error = %v, want ErrUnknown", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	db := setup(t)
+	if err := Init(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance saves a value; this one keeps its cache until it
+	// expires
+	db.Create(&model.Setting{Key: string(LoginPassword), Value: "true"})
+	if Bool(LoginPassword) {
+		t.Fatal("a fresh cache was reloaded")
+	}
+
+	mu.Lock()
+	loadedAt = time.Now().Add(-cacheTTL - time.Second)
+	mu.Unlock()
+	if !Bool(LoginPassword) {
+		t.Error("the saved value was not loaded after the cache expired")
+	}
+
+	// A failed reload keeps the cached values
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	mu.Lock()
+	loadedAt = time.Now().Add(-cacheTTL - time.Second)
+	mu.Unlock()
+	if !Bool(LoginPassword) {
+		t.Error("a failed reload dropped the cached value")
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory keeps files in memory. It is meant for tests and throwaway
+// development instances: files are lost on restart and are not served.
+type Memory struct {
+	mu      sync.RWMutex
+	files   map[string]MemoryFile
+	baseURL string
+}
+
+// MemoryFile is a file held by the Memory driver.
+type MemoryFile struct {
+	Data        []byte
+	ContentType string
+}
+
+// NewMemory returns an empty in-memory driver whose URLs start with baseURL.
+func NewMemory(baseURL string) *Memory {
+	return &Memory{files: map[string]MemoryFile{}, baseURL: baseURL}
+}
+
+// Put reads r into memory.
+func (s *Memory) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = MemoryFile{Data: data, ContentType: contentType}
+	return nil
+}
+
+// Get returns a reader over a copy of the file stored under key.
+func (s *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, ok := s.File(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(file.Data)), nil
+}
+
+// File returns the file stored under key, letting tests inspect uploads.
+func (s *Memory) File(key string) (MemoryFile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, ok := s.files[key]
+	if ok {
+		file.Data = append([]byte(nil), file.Data...)
+	}
+	return file, ok
+}
+
+// Delete forgets the file stored under key.
+func (s *Memory) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, key)
+	return nil
+}
+
+// List returns the stored keys starting with prefix, sorted.
+func (s *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []string
+	for key := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Exists checks whether a file is stored under key.
+func (s *Memory) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.files[key]
+	return ok, nil
+}
+
+// URL returns baseURL followed by key.
+func (s *Memory) URL(key string) string {
+	return s.baseURL + key
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dariubs/scaffold/app/config"
+)
+
+// S3Options configures an S3 driver.
+type S3Options struct {
+	// Endpoint is the S3 API endpoint, e.g. http://localhost:9000 for MinIO.
+	// Empty uses AWS S3 in Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// AccessKeyID and SecretAccessKey may be empty to use the AWS default
+	// credential chain (environment, shared config, instance role).
+	AccessKeyID     string
+	SecretAccessKey string
+	// UsePathStyle addresses objects as <endpoint>/<bucket>/<key> instead of
+	// <bucket>.<endpoint host>/<key>. MinIO usually needs it.
+	UsePathStyle bool
+	// PublicURL is the base URL of public links, e.g. a CDN or custom domain.
+	// Empty derives it from the endpoint and bucket.
+	PublicURL string
+	// ACL is the canned ACL set on uploaded objects, e.g. "public-read".
+	// Empty sets none, for buckets that disallow ACLs.
+	ACL string
+}
+
+// S3 stores files in a bucket of any S3-compatible service: AWS S3, MinIO,
+// Backblaze B2 or Cloudflare R2.
+type S3 struct {
+	client    *s3.Client
+	bucket    string
+	acl       types.ObjectCannedACL
+	publicURL string
+}
+
+// NewS3 returns an S3 driver for opts.
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is not configured")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	publicURL, err := s3PublicURL(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	loadOptions := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(opts.Region)}
+	if opts.AccessKeyID != "" {
+		loadOptions = append(loadOptions, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.UsePathStyle
+	})
+	return &S3{
+		client:    client,
+		bucket:    opts.Bucket,
+		acl:       types.ObjectCannedACL(opts.ACL),
+		publicURL: publicURL,
+	}, nil
+}
+
+// s3PublicURL returns the base URL of public links for opts, ending with a
+// slash.
+func s3PublicURL(opts S3Options) (string, error) {
+	if opts.PublicURL != "" {
+		return strings.TrimRight(opts.PublicURL, "/") + "/", nil
+	}
+	if opts.Endpoint == "" {
+		if opts.UsePathStyle {
+			return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/", opts.Region, opts.Bucket), nil
+		}
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", opts.Bucket, opts.Region), nil
+	}
+	u, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	if opts.UsePathStyle {
+		return u.String() + "/" + opts.Bucket + "/", nil
+	}
+	u.Host = opts.Bucket + "." + u.Host
+	return u.String() + "/", nil
+}
+
+// NewS3FromConfig returns an S3 driver configured from the S3_* variables.
+func NewS3FromConfig() (*S3, error) {
+	c := config.C.S3
+	return NewS3(S3Options{
+		Endpoint:        c.Endpoint,
+		Region:          c.Region,
+		Bucket:          c.Bucket,
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		UsePathStyle:    c.UsePathStyle,
+		PublicURL:       c.PublicURL,
+		ACL:             c.ACL,
+	})
+}
+
+// NewR2 returns an S3 driver for the Cloudflare R2 bucket configured by the
+// CLOUDFLARE_* variables.
+func NewR2() (*S3, error) {
+	// Check if R2 configuration is available
+	if !config.C.R2Configured() {
+		return nil, fmt.Errorf("R2 configuration is incomplete")
+	}
+	c := config.C.CloudflareR2
+	// The S3 API endpoint does not serve public reads, so links need the
+	// bucket's r2.dev URL or custom domain
+	if c.PublicURL == "" {
+		return nil, fmt.Errorf("CLOUDFLARE_R2_PUBLIC_URL is not configured")
+	}
+	return NewS3(S3Options{
+		Endpoint:        fmt.Sprintf("https://%s.r2.cloudflarestorage.com", c.AccountID),
+		Region:          c.Region,
+		Bucket:          c.Bucket,
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		UsePathStyle:    true,
+		PublicURL:       c.PublicURL,
+	})
+}
+
+// Put uploads r to the bucket.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+		ACL:         s.acl,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file to S3: %v", err)
+	}
+	return nil
+}
+
+// Get downloads the object stored under key.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to download file from S3: %v", err)
+	}
+	return out.Body, nil
+}
+
+// Delete deletes the object stored under key.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file from S3: %v", err)
+	}
+	return nil
+}
+
+// List lists the objects whose key starts with prefix.
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %v", err)
+		}
+		for _, object := range page.Contents {
+			if object.Key != nil {
+				keys = append(keys, *object.Key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// Exists checks whether an object is stored under key.
+func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
+	if err := CheckKey(key); err != nil {
+		return false, err
+	}
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// URL returns the public URL of key.
+func (s *S3) URL(key string) string {
+	return s.publicURL + key
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/config"
+)
+
+func TestS3PublicURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    S3Options
+		want    string
+		wantErr bool
+	}{
+		{"aws", S3Options{Region: "eu-west-1", Bucket: "b"}, "https://b.s3.eu-west-1.amazonaws.com/", false},
+		{"aws path style", S3Options{Region: "eu-west-1", Bucket: "b", UsePathStyle: true}, "https://s3.eu-west-1.amazonaws.com/b/", false},
+		{"endpoint", S3Options{Endpoint: "https://s3.example.com", Bucket: "b"}, "https://b.s3.example.com/", false},
+		{"endpoint path style", S3Options{Endpoint: "http://localhost:9000/", Bucket: "b", UsePathStyle: true}, "http://localhost:9000/b/", false},
+		{"public url", S3Options{Endpoint: "http://localhost:9000", Bucket: "b", PublicURL: "https://cdn.example.com/media"}, "https://cdn.example.com/media/", false},
+		{"public url with slash", S3Options{Bucket: "b", PublicURL: "https://cdn.example.com/"}, "https://cdn.example.com/", false},
+		{"endpoint without scheme", S3Options{Endpoint: "localhost:9000", Bucket: "b"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s3PublicURL(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("s3PublicURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("s3PublicURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewR2(t *testing.T) {
+	config.C = &config.Config{}
+	r2 := &config.C.CloudflareR2
+	r2.AccountID, r2.AccessKeyID, r2.SecretAccessKey, r2.Bucket = "acct", "key", "secret", "bucket"
+
+	if _, err := NewR2(); err == nil {
+		t.Fatal("NewR2() without a public URL succeeded")
+	}
+	r2.PublicURL = "https://pub-123.r2.dev"
+	s, err := NewR2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.URL("profiles/a.png"); got != "https://pub-123.r2.dev/profiles/a.png" {
+		t.Errorf("URL() = %q", got)
+	}
+}
+
+// fakeS3 serves the subset of the S3 API the driver uses, for path-style
+// requests to one bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		prefix := r.URL.Query().Get("prefix")
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Name     string
+			Prefix   string
+			KeyCount int
+			Contents []struct{ Key string }
+		}{Name: f.bucket, Prefix: prefix}
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, struct{ Key string }{k})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+			}
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{bucket: "uploads", objects: map[string][]byte{}})
+	defer srv.Close()
+
+	s, err := NewS3(S3Options{
+		Endpoint:        srv.URL,
+		Bucket:          "uploads",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		UsePathStyle:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.URL("a.png"), srv.URL+"/uploads/a.png"; got != want {
+		t.Errorf("URL() = %q, want %q", got, want)
+	}
+	testDriver(t, s)
+}
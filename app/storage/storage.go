@@ -0,0 +1,116 @@
+// Package storage stores uploaded files. Handlers depend on the Storage
+// interface; the driver behind it is chosen by STORAGE_DRIVER.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/google/uuid"
+)
+
+// Storage drivers selectable with STORAGE_DRIVER.
+const (
+	DriverS3     = "s3"
+	DriverR2     = "r2"
+	DriverLocal  = "local"
+	DriverMemory = "memory"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist.
+	ErrNotFound = errors.New("storage: file not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute or contain
+	// "." or ".." segments.
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrInvalidFolder is returned by NewKey for folder names other than
+	// letters, digits, dashes and underscores.
+	ErrInvalidFolder = errors.New("storage: invalid folder")
+)
+
+// Storage is a flat store of files addressed by slash-separated keys such as
+// "profiles/profiles-<uuid>.png".
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing file.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get opens the file stored under key. It returns ErrNotFound if there is
+	// none; the caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the file stored under key. Deleting a missing file is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys that start with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Exists reports whether a file is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL returns the public URL of key.
+	URL(key string) string
+}
+
+// New returns the driver selected by STORAGE_DRIVER.
+func New() (Storage, error) {
+	switch config.C.Storage.Driver {
+	case DriverS3:
+		s, err := NewS3FromConfig()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case DriverR2:
+		s, err := NewR2()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case DriverLocal:
+		return NewLocal(config.C.Storage.LocalDir, config.C.URL(config.C.Storage.URLPath+"/"))
+	case DriverMemory:
+		return NewMemory(config.C.URL(config.C.Storage.URLPath + "/")), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", config.C.Storage.Driver)
+	}
+}
+
+// CheckKey returns ErrInvalidKey unless key is a relative, clean path.
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+var folderPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// NewKey returns a new unique key in folder ending with ext, e.g. ".png".
+func NewKey(folder, ext string) (string, error) {
+	if !folderPattern.MatchString(folder) {
+		return "", ErrInvalidFolder
+	}
+	return path.Join(folder, fmt.Sprintf("%s-%s%s", folder, uuid.New().String(), ext)), nil
+}
+
+// KeyFromURL returns the key of a file URL returned by s.URL. It returns
+// false for URLs that do not point into s, such as avatars from OAuth
+// providers.
+func KeyFromURL(s Storage, fileURL string) (string, bool) {
+	base := s.URL("")
+	if base == "" || !strings.HasPrefix(fileURL, base) {
+		return "", false
+	}
+	key := strings.TrimPrefix(fileURL, base)
+	if CheckKey(key) != nil {
+		return "", false
+	}
+	return key, true
+}
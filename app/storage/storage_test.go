@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{"profiles/a.png", nil},
+		{"a.png", nil},
+		{"a/b/c.png", nil},
+		{"", ErrInvalidKey},
+		{"/etc/passwd", ErrInvalidKey},
+		{"../a.png", ErrInvalidKey},
+		{"a/../../b.png", ErrInvalidKey},
+		{"a/./b.png", ErrInvalidKey},
+		{"a//b.png", ErrInvalidKey},
+		{"a/", ErrInvalidKey},
+		{`a\b.png`, ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		if got := CheckKey(tt.key); got != tt.want {
+			t.Errorf("CheckKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	key, err := NewKey("profiles", ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "profiles/profiles-") || !strings.HasSuffix(key, ".png") || CheckKey(key) != nil {
+		t.Errorf("NewKey() = %q", key)
+	}
+	if other, _ := NewKey("profiles", ".png"); other == key {
+		t.Errorf("NewKey() returned %q twice", key)
+	}
+	for _, folder := range []string{"", "a/b", "..", "a b", "a.b"} {
+		if _, err := NewKey(folder, ".png"); err != ErrInvalidFolder {
+			t.Errorf("NewKey(%q) error = %v, want ErrInvalidFolder", folder, err)
+		}
+	}
+}
+
+func TestKeyFromURL(t *testing.T) {
+	s := NewMemory("https://cdn.example.com/files/")
+	tests := []struct {
+		url    string
+		want   string
+		wantOK bool
+	}{
+		{"https://cdn.example.com/files/profiles/a.png", "profiles/a.png", true},
+		{"https://cdn.example.com/files/", "", false},
+		{"https://cdn.example.com/files/../secret", "", false},
+		{"https://cdn.example.com/other/a.png", "", false},
+		{"https://lh3.googleusercontent.com/a/photo.jpg", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := KeyFromURL(s, tt.url)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("KeyFromURL(%q) = %q, %v, want %q, %v", tt.url, got, ok, tt.want, tt.wantOK)
+		}
+	}
+	if _, ok := KeyFromURL(NewMemory(""), "profiles/a.png"); ok {
+		t.Error("KeyFromURL() matched a driver without a base URL")
+	}
+}
+
+// testDriver runs the behaviour every Storage implementation shares.
+func testDriver(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, key := range []string{"profiles/a.png", "profiles/b.png", "general/c.png"} {
+		if err := s.Put(ctx, key, strings.NewReader("data "+key), "image/png"); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+	if err := s.Put(ctx, "../escape.png", strings.NewReader("x"), "image/png"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put(../escape.png) error = %v, want ErrInvalidKey", err)
+	}
+
+	r, err := s.Get(ctx, "profiles/a.png")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "data profiles/a.png" {
+		t.Errorf("Get() = %q", data)
+	}
+	if _, err := s.Get(ctx, "profiles/missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+
+	keys, err := s.List(ctx, "profiles/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if want := []string{"profiles/a.png", "profiles/b.png"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	if ok, err := s.Exists(ctx, "general/c.png"); err != nil || !ok {
+		t.Errorf("Exists(general/c.png) = %v, %v", ok, err)
+	}
+	if err := s.Delete(ctx, "general/c.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ok, err := s.Exists(ctx, "general/c.png"); err != nil || ok {
+		t.Errorf("Exists() after Delete = %v, %v", ok, err)
+	}
+	if err := s.Delete(ctx, "general/c.png"); err != nil {
+		t.Errorf("Delete(missing) = %v, want nil", err)
+	}
+
+	if key, ok := KeyFromURL(s, s.URL("profiles/b.png")); !ok || key != "profiles/b.png" {
+		t.Errorf("KeyFromURL(URL()) = %q, %v", key, ok)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory("http://localhost/files/")
+	testDriver(t, s)
+	if file, ok := s.File("profiles/a.png"); !ok || file.ContentType != "image/png" {
+		t.Errorf("File() = %+v, %v", file, ok)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	s, err := NewLocal(t.TempDir(), "http://localhost/files/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDriver(t, s)
+}
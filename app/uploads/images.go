@@ -0,0 +1,252 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// jpegQuality is the quality JPEG images and their variants are encoded at.
+const jpegQuality = 90
+
+// processedImage is an image ready to be stored.
+type processedImage struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	Variants    []variant
+}
+
+// variant is a resized copy of an image.
+type variant struct {
+	Size int
+	Data []byte
+}
+
+// processImage re-encodes an image that passed Validate. Re-encoding drops
+// EXIF, XMP and other metadata, such as the GPS position recorded by phones;
+// the EXIF orientation of JPEGs is applied to the pixels first so the image
+// still displays upright. The image is converted to rule.Format if set, and a
+// copy is made for each of rule.Variants.
+//
+// Animated GIFs keep their frames, but their variants show the first frame.
+// There is no WebP encoder, so WebP images are stored as PNG if they have
+// transparency and as JPEG otherwise.
+func processImage(data []byte, contentType string, rule Rule) (processedImage, error) {
+	target := rule.Format
+	if target == "" {
+		target = contentType
+	}
+
+	var (
+		img  image.Image
+		anim *gif.GIF
+		err  error
+	)
+	if contentType == "image/gif" && target == "image/gif" {
+		anim, err = gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(anim.Image) == 0 {
+			return processedImage{}, invalid(ErrInvalidImage, "The file is not a valid image")
+		}
+		img = anim.Image[0]
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return processedImage{}, invalid(ErrInvalidImage, "The file is not a valid image")
+		}
+		if contentType == "image/jpeg" {
+			img = orient(img, exifOrientation(data))
+		}
+	}
+	if target == "image/webp" {
+		target = "image/jpeg"
+		if !opaque(img) {
+			target = "image/png"
+		}
+	}
+
+	out := processedImage{ContentType: target}
+	var buf bytes.Buffer
+	if anim != nil {
+		err = gif.EncodeAll(&buf, anim)
+		out.Width, out.Height = anim.Config.Width, anim.Config.Height
+	} else {
+		err = encodeImage(&buf, img, target)
+		out.Width, out.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	if err != nil {
+		return processedImage{}, fmt.Errorf("failed to encode image: %w", err)
+	}
+	out.Data = buf.Bytes()
+
+	for _, size := range rule.Variants {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, fit(img, size), target); err != nil {
+			return processedImage{}, fmt.Errorf("failed to encode %dpx variant: %w", size, err)
+		}
+		out.Variants = append(out.Variants, variant{Size: size, Data: buf.Bytes()})
+	}
+	return out, nil
+}
+
+// encodeImage writes img to w as contentType. JPEG has no transparency, so
+// transparent images are flattened onto white.
+func encodeImage(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		if !opaque(img) {
+			flat := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+			draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+			draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+			img = flat
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		return png.Encode(w, img)
+	case "image/gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("cannot encode %s", contentType)
+	}
+}
+
+// fit scales img down to fit in a size by size box, keeping its aspect
+// ratio. Smaller images are returned unchanged rather than enlarged.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		w, h = size, max(1, (h*size+w/2)/w)
+	} else {
+		w, h = max(1, (w*size+h/2)/h), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// opaque reports whether img has no transparent pixels.
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
+
+// orient transforms img so that it displays upright, given its EXIF
+// orientation (1-8). Orientation 1 and unknown values leave img unchanged.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// Orientations 5-8 swap width and height
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Needs rotating 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // Needs rotating 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// exifOrientation returns the orientation recorded in a JPEG's EXIF data, or
+// 1 (upright) if there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Metadata segments all come before the image data
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return 1
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// tiffOrientation reads the Orientation tag (0x0112) from the first IFD of
+// TIFF-structured EXIF data.
+func tiffOrientation(b []byte) int {
+	if len(b) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(b[4:]))
+	if ifd < 8 || ifd+2 > len(b) {
+		return 1
+	}
+	entries := int(order.Uint16(b[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(b) {
+			break
+		}
+		if order.Uint16(b[entry:]) == 0x0112 {
+			if v := int(order.Uint16(b[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
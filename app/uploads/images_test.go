@@ -0,0 +1,209 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// exifTIFF returns TIFF-structured EXIF data whose first IFD holds only an
+// Orientation tag.
+func exifTIFF(order binary.AppendByteOrder, orientation uint16) []byte {
+	var b []byte
+	if order == binary.LittleEndian {
+		b = []byte("II")
+	} else {
+		b = []byte("MM")
+	}
+	b = order.AppendUint16(b, 42)
+	b = order.AppendUint32(b, 8) // First IFD offset
+	b = order.AppendUint16(b, 1) // Entry count
+	b = order.AppendUint16(b, 0x0112)
+	b = order.AppendUint16(b, 3) // SHORT
+	b = order.AppendUint32(b, 1)
+	b = order.AppendUint16(b, orientation)
+	b = append(b, 0, 0)
+	return order.AppendUint32(b, 0) // No next IFD
+}
+
+// withEXIF inserts an APP1 EXIF segment holding tiff after the SOI marker.
+func withEXIF(jpg, tiff []byte) []byte {
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestEXIFOrientation(t *testing.T) {
+	jpg := jpegBytes(t, 4, 2)
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", jpg, 1},
+		{"big endian", withEXIF(jpg, exifTIFF(binary.BigEndian, 6)), 6},
+		{"little endian", withEXIF(jpg, exifTIFF(binary.LittleEndian, 8)), 8},
+		{"out of range", withEXIF(jpg, exifTIFF(binary.BigEndian, 9)), 1},
+		{"bad byte order", withEXIF(jpg, append([]byte("XX"), exifTIFF(binary.BigEndian, 6)[2:]...)), 1},
+		{"truncated tiff", withEXIF(jpg, exifTIFF(binary.BigEndian, 6)[:12]), 1},
+		{"not a jpeg", pngBytes(t, 4, 2), 1},
+		{"empty", nil, 1},
+		{"bad segment length", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E'}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.data); got != tt.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// indexed returns a w by h image whose pixel at (x, y) has red value
+// x + y*w, so tests can tell where each pixel moved.
+func indexed(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x + y*w), A: 255})
+		}
+	}
+	return img
+}
+
+// pixels returns the red values of img row by row.
+func pixels(img image.Image) [][]int {
+	b := img.Bounds()
+	var rows [][]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []int
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row = append(row, int(r>>8))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	// The source is 3x2:
+	//   0 1 2
+	//   3 4 5
+	tests := []struct {
+		orientation int
+		want        [][]int
+	}{
+		{0, [][]int{{0, 1, 2}, {3, 4, 5}}},
+		{1, [][]int{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]int{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]int{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]int{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]int{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]int{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]int{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]int{{2, 5}, {1, 4}, {0, 3}}},
+		{9, [][]int{{0, 1, 2}, {3, 4, 5}}},
+	}
+	for _, tt := range tests {
+		if got := pixels(orient(indexed(3, 2), tt.orientation)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("orient(%d) = %v, want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, size   int
+		wantW, wantH int
+	}{
+		{100, 50, 200, 100, 50},
+		{100, 100, 100, 100, 100},
+		{400, 200, 100, 100, 50},
+		{200, 400, 100, 50, 100},
+		{1000, 1, 100, 100, 1},
+	}
+	for _, tt := range tests {
+		b := fit(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), tt.size).Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("fit(%dx%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.size, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	t.Run("jpeg orientation and metadata", func(t *testing.T) {
+		data := withEXIF(jpegBytes(t, 40, 20), exifTIFF(binary.BigEndian, 6))
+		out, err := processImage(data, "image/jpeg", Rule{Variants: []int{10}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.ContentType != "image/jpeg" || out.Width != 20 || out.Height != 40 {
+			t.Errorf("processImage() = %s %dx%d, want image/jpeg 20x40", out.ContentType, out.Width, out.Height)
+		}
+		if bytes.Contains(out.Data, []byte("Exif")) {
+			t.Error("EXIF data was kept")
+		}
+		if len(out.Variants) != 1 || out.Variants[0].Size != 10 {
+			t.Fatalf("variants = %+v", out.Variants)
+		}
+		v, err := jpeg.DecodeConfig(bytes.NewReader(out.Variants[0].Data))
+		if err != nil || v.Width != 5 || v.Height != 10 {
+			t.Errorf("variant = %+v, %v, want 5x10", v, err)
+		}
+	})
+
+	t.Run("convert format", func(t *testing.T) {
+		src := image.NewNRGBA(image.Rect(0, 0, 4, 4)) // Fully transparent
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		out, err := processImage(buf.Bytes(), "image/png", Rule{Format: "image/jpeg"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(out.Data))
+		if err != nil {
+			t.Fatalf("output is not a JPEG: %v", err)
+		}
+		if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+			t.Errorf("transparent pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+		}
+	})
+
+	t.Run("animated gif", func(t *testing.T) {
+		out, err := processImage(gifBytes(t, 20, 10, 3), "image/gif", Rule{Variants: []int{5}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(out.Data))
+		if err != nil || len(anim.Image) != 3 {
+			t.Fatalf("output frames = %v, %v, want 3", anim, err)
+		}
+		if out.Width != 20 || out.Height != 10 {
+			t.Errorf("size = %dx%d, want 20x10", out.Width, out.Height)
+		}
+		v, err := gif.DecodeAll(bytes.NewReader(out.Variants[0].Data))
+		if err != nil || len(v.Image) != 1 || v.Config.Width != 5 {
+			t.Errorf("variant = %v, %v, want one 5px wide frame", v, err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := processImage([]byte("not an image"), "image/png", Rule{})
+		if !errors.Is(err, ErrInvalidImage) {
+			t.Errorf("processImage() error = %v, want ErrInvalidImage", err)
+		}
+	})
+}
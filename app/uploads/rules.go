@@ -0,0 +1,217 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for Validate
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Rule limits what may be stored in a folder.
+type Rule struct {
+	MaxSize int64    // Bytes
+	Types   []string // Allowed content types, as detected from the file's contents
+	// MaxWidth and MaxHeight bound the pixel dimensions of images, which are
+	// checked before decoding so oversized images cannot exhaust memory.
+	MaxWidth  int
+	MaxHeight int
+	// Variants are the sizes of the resized copies stored next to each image,
+	// each fitting in a size by size box.
+	Variants []int
+	// Format is the content type images are converted to, e.g. "image/jpeg".
+	// Empty keeps the uploaded format.
+	Format string
+}
+
+// ImageTypes are the image formats accepted for uploads.
+var ImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// Rules holds the rule of each folder. Folders without an entry use
+// DefaultRule.
+var Rules = map[string]Rule{
+	"profiles": {MaxSize: 5 << 20, Types: ImageTypes, MaxWidth: 4096, MaxHeight: 4096, Variants: []int{64, 256, 1024}},
+}
+
+// DefaultRule applies to folders missing from Rules.
+var DefaultRule = Rule{MaxSize: 10 << 20, Types: ImageTypes, MaxWidth: 6000, MaxHeight: 6000}
+
+// RuleFor returns the rule for folder.
+func RuleFor(folder string) Rule {
+	if rule, ok := Rules[folder]; ok {
+		return rule
+	}
+	return DefaultRule
+}
+
+// extensions maps accepted content types to the extension of stored keys.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// imageFormats maps image content types to the format name reported by the
+// image package.
+var imageFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Validation failures, wrapped in a *ValidationError.
+var (
+	ErrTooLarge     = errors.New("uploads: file too large")
+	ErrType         = errors.New("uploads: file type not allowed")
+	ErrInvalidImage = errors.New("uploads: invalid image")
+	ErrDimensions   = errors.New("uploads: image dimensions too large")
+)
+
+// ValidationError is returned when a file breaks its folder's rule. Message
+// is suitable for showing to the user.
+type ValidationError struct {
+	Err     error
+	Message string
+}
+
+func (e *ValidationError) Error() string { return e.Message }
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+func invalid(err error, format string, args ...interface{}) error {
+	return &ValidationError{Err: err, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks data against rule and returns its content type, detected
+// from the magic bytes. Images are fully decoded, so files that only start
+// like an image are rejected. The client's filename and Content-Type are
+// never consulted.
+func Validate(data []byte, rule Rule) (string, error) {
+	if int64(len(data)) > rule.MaxSize {
+		return "", invalid(ErrTooLarge, "File too large. Maximum size is %s", formatMB(rule.MaxSize))
+	}
+	contentType := http.DetectContentType(data)
+	if !allowed(rule.Types, contentType) {
+		return "", invalid(ErrType, "Invalid file type. Allowed types: %s", describeTypes(rule.Types))
+	}
+
+	format, ok := imageFormats[contentType]
+	if !ok {
+		return contentType, nil
+	}
+	cfg, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return "", invalid(ErrInvalidImage, "The file is not a valid image")
+	}
+	if cfg.Width > rule.MaxWidth || cfg.Height > rule.MaxHeight {
+		return "", invalid(ErrDimensions, "Image too large. Maximum size is %dx%d pixels", rule.MaxWidth, rule.MaxHeight)
+	}
+	if format == "gif" {
+		// Every frame of an animation is decoded when it is processed, so
+		// the frames together get the pixel budget of one image
+		frames, pixels, err := gifFrames(data)
+		if err != nil {
+			return "", invalid(ErrInvalidImage, "The file is not a valid image")
+		}
+		if frames > maxGIFFrames || pixels > int64(rule.MaxWidth)*int64(rule.MaxHeight) {
+			return "", invalid(ErrDimensions, "Animation too large. Its frames may add up to at most %dx%d pixels", rule.MaxWidth, rule.MaxHeight)
+		}
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return "", invalid(ErrInvalidImage, "The file is not a valid image")
+	}
+	return contentType, nil
+}
+
+// maxGIFFrames caps the number of frames of animated GIFs.
+const maxGIFFrames = 1000
+
+// gifFrames counts the frames of a GIF and their total area in pixels by
+// walking its blocks, without decompressing any image data.
+func gifFrames(data []byte) (frames int, pixels int64, err error) {
+	errFormat := errors.New("uploads: malformed GIF")
+	if len(data) < 13 {
+		return 0, 0, errFormat
+	}
+	i := 13 // Header and logical screen descriptor
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&7 + 1) // Global color table
+	}
+	// skipSubBlocks moves i past a sequence of data sub-blocks.
+	skipSubBlocks := func() error {
+		for {
+			if i >= len(data) {
+				return errFormat
+			}
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				return nil
+			}
+		}
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: introducer, label, sub-blocks
+			i += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // Image descriptor, optional local color table, image data
+			if i+10 > len(data) {
+				return 0, 0, errFormat
+			}
+			w := int64(data[i+5]) | int64(data[i+6])<<8
+			h := int64(data[i+7]) | int64(data[i+8])<<8
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&7 + 1)
+			}
+			i++ // LZW minimum code size
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			pixels += w * h
+		case 0x3B: // Trailer
+			return frames, pixels, nil
+		default:
+			return 0, 0, errFormat
+		}
+	}
+	return frames, pixels, nil
+}
+
+func allowed(types []string, contentType string) bool {
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// describeTypes lists types for error messages, e.g. "JPEG, PNG".
+func describeTypes(types []string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = strings.ToUpper(strings.TrimPrefix(t, "image/"))
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatMB returns n bytes as whole megabytes, e.g. "5MB".
+func formatMB(n int64) string {
+	if n%(1<<20) == 0 {
+		return fmt.Sprintf("%dMB", n>>20)
+	}
+	return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+}
@@ -0,0 +1,126 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func pngBytes(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func jpegBytes(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gifBytes(t *testing.T, w, h, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, w, h), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidate(t *testing.T) {
+	rule := Rule{MaxSize: 1 << 20, Types: ImageTypes, MaxWidth: 100, MaxHeight: 100}
+	png := pngBytes(t, 10, 10)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		rule    Rule
+		want    string
+		wantErr error
+	}{
+		{"png", png, rule, "image/png", nil},
+		{"jpeg", jpegBytes(t, 10, 10), rule, "image/jpeg", nil},
+		{"gif", gifBytes(t, 10, 10, 1), rule, "image/gif", nil},
+		{"animated gif within budget", gifBytes(t, 50, 50, 4), rule, "image/gif", nil},
+		{"too large", png, Rule{MaxSize: 10, Types: ImageTypes, MaxWidth: 100, MaxHeight: 100}, "", ErrTooLarge},
+		{"html", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), rule, "", ErrType},
+		{"type not in rule", png, Rule{MaxSize: 1 << 20, Types: []string{"image/jpeg"}, MaxWidth: 100, MaxHeight: 100}, "", ErrType},
+		{"truncated", png[:len(png)/2], rule, "", ErrInvalidImage},
+		{"png header only", png[:16], rule, "", ErrInvalidImage},
+		{"too wide", pngBytes(t, 101, 1), rule, "", ErrDimensions},
+		{"too tall", jpegBytes(t, 1, 101), rule, "", ErrDimensions},
+		// Each frame fits, but decoding all of them would not
+		{"animation over budget", gifBytes(t, 100, 100, 2), rule, "", ErrDimensions},
+		{"too many frames", gifBytes(t, 1, 1, maxGIFFrames+1), rule, "", ErrDimensions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Validate(tt.data, tt.rule)
+			if tt.wantErr != nil {
+				var invalid *ValidationError
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &invalid) || invalid.Message == "" {
+					t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	frames, pixels, err := gifFrames(gifBytes(t, 20, 10, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 3 || pixels != 600 {
+		t.Errorf("gifFrames() = %d frames, %d pixels, want 3, 600", frames, pixels)
+	}
+	if _, _, err := gifFrames([]byte("GIF89a")); err == nil {
+		t.Error("gifFrames() accepted a truncated header")
+	}
+	data := gifBytes(t, 20, 10, 1)
+	data[len(data)-1] = 0x99 // Unknown block in place of the trailer
+	if _, _, err := gifFrames(data); err == nil {
+		t.Error("gifFrames() accepted an unknown block")
+	}
+}
+
+func TestRuleFor(t *testing.T) {
+	if got := RuleFor("profiles"); got.MaxSize != Rules["profiles"].MaxSize {
+		t.Errorf("RuleFor(profiles) = %+v", got)
+	}
+	if got := RuleFor("general"); got.MaxSize != DefaultRule.MaxSize {
+		t.Errorf("RuleFor(general) = %+v, want DefaultRule", got)
+	}
+}
+
+func TestFormatMB(t *testing.T) {
+	for n, want := range map[int64]string{5 << 20: "5MB", 10 << 20: "10MB", 1536 << 10: "1.5MB"} {
+		if got := formatMB(n); got != want {
+			t.Errorf("formatMB(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
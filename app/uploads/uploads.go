@@ -0,0 +1,176 @@
+// Package uploads stores files through app/storage and records each one in
+// the uploads table, so every stored file has an owner. Handlers should store
+// and delete files through this package rather than calling the storage
+// driver directly.
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"strings"
+
+	"github.com/dariubs/scaffold/app/model"
+	"github.com/dariubs/scaffold/app/storage"
+	"github.com/dariubs/scaffold/app/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNotFound is returned when a URL does not point to a recorded upload.
+	ErrNotFound = errors.New("uploads: file not found")
+	// ErrNotOwner is returned when a user acts on another user's upload.
+	ErrNotOwner = errors.New("uploads: file belongs to another user")
+)
+
+// Save validates file against its folder's rule, stores it on behalf of
+// userID and records it. Images are processed first: metadata is stripped and
+// the rule's variants are stored next to the original. Validation failures
+// are *ValidationError values.
+func Save(ctx context.Context, db *gorm.DB, store storage.Storage, userID uint, file *multipart.FileHeader, folder string) (model.Upload, error) {
+	rule := RuleFor(folder)
+	if file.Size > rule.MaxSize {
+		return model.Upload{}, invalid(ErrTooLarge, "File too large. Maximum size is %s", formatMB(rule.MaxSize))
+	}
+	src, err := file.Open()
+	if err != nil {
+		return model.Upload{}, fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+	// Read one byte past the limit so Validate sees oversized files
+	data, err := io.ReadAll(io.LimitReader(src, rule.MaxSize+1))
+	if err != nil {
+		return model.Upload{}, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+
+	contentType, err := Validate(data, rule)
+	if err != nil {
+		return model.Upload{}, err
+	}
+	upload := model.Upload{UserID: userID, Folder: folder}
+	var variants []variant
+	if _, ok := imageFormats[contentType]; ok {
+		img, err := processImage(data, contentType, rule)
+		if err != nil {
+			return model.Upload{}, err
+		}
+		data, contentType, variants = img.Data, img.ContentType, img.Variants
+		upload.Width, upload.Height = img.Width, img.Height
+	}
+
+	key, err := storage.NewKey(folder, extensions[contentType])
+	if err != nil {
+		return model.Upload{}, err
+	}
+	upload.Key = key
+	sizes := make([]string, len(variants))
+	for i, v := range variants {
+		sizes[i] = strconv.Itoa(v.Size)
+	}
+	upload.Variants = strings.Join(sizes, ",")
+
+	if err := store.Put(ctx, key, bytes.NewReader(data), contentType); err != nil {
+		return model.Upload{}, err
+	}
+	for _, v := range variants {
+		if err := store.Put(ctx, upload.VariantKey(v.Size), bytes.NewReader(v.Data), contentType); err != nil {
+			deleteFiles(ctx, store, upload)
+			return model.Upload{}, err
+		}
+	}
+	sum := sha256.Sum256(data)
+	upload.Size = int64(len(data))
+	upload.ContentType = contentType
+	upload.Checksum = hex.EncodeToString(sum[:])
+	if err := db.Create(&upload).Error; err != nil {
+		// Don't leave files behind that nobody owns
+		deleteFiles(ctx, store, upload)
+		return model.Upload{}, err
+	}
+	return upload, nil
+}
+
+// files returns the keys of upload's original and its variants.
+func files(upload model.Upload) []string {
+	keys := []string{upload.Key}
+	for _, size := range upload.VariantSizes() {
+		keys = append(keys, upload.VariantKey(size))
+	}
+	return keys
+}
+
+// deleteFiles removes upload's stored files after a failed Save, logging
+// rather than returning errors.
+func deleteFiles(ctx context.Context, store storage.Storage, upload model.Upload) {
+	for _, key := range files(upload) {
+		if err := store.Delete(ctx, key); err != nil {
+			utils.Logger.Warn("Failed to delete unrecorded upload", "err", err, "key", key)
+		}
+	}
+}
+
+// FindByURL returns the upload fileURL points to.
+func FindByURL(db *gorm.DB, store storage.Storage, fileURL string) (model.Upload, error) {
+	var upload model.Upload
+	key, ok := storage.KeyFromURL(store, fileURL)
+	if !ok {
+		return upload, ErrNotFound
+	}
+	if err := db.Where("key = ?", key).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return upload, ErrNotFound
+		}
+		return upload, err
+	}
+	return upload, nil
+}
+
+// Delete deletes the stored file, its variants and its record, and clears
+// the avatar of any user still pointing at it. Files are deleted before the
+// record and files already gone count as deleted, so a Delete that fails
+// part way can be retried until the record goes too.
+func Delete(ctx context.Context, db *gorm.DB, store storage.Storage, upload model.Upload) error {
+	for _, key := range files(upload) {
+		if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&upload).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&model.User{}).
+			Where("avatar_url = ?", store.URL(upload.Key)).
+			Updates(map[string]interface{}{"avatar_url": "", "avatar_variants": ""}).Error
+	})
+}
+
+// DeleteOwned deletes the upload at fileURL if userID owns it. It returns
+// ErrNotFound or ErrNotOwner otherwise.
+func DeleteOwned(ctx context.Context, db *gorm.DB, store storage.Storage, userID uint, fileURL string) (model.Upload, error) {
+	upload, err := FindByURL(db, store, fileURL)
+	if err != nil {
+		return upload, err
+	}
+	if upload.UserID != userID {
+		return upload, ErrNotOwner
+	}
+	return upload, Delete(ctx, db, store, upload)
+}
+
+// Discard deletes a file userID no longer uses, such as a replaced avatar.
+// URLs that are not uploads owned by userID, such as avatars from OAuth
+// providers, are left alone.
+func Discard(ctx context.Context, db *gorm.DB, store storage.Storage, userID uint, fileURL string) error {
+	_, err := DeleteOwned(ctx, db, store, userID, fileURL)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNotOwner) {
+		return nil
+	}
+	return err
+}
@@ -43,3 +43,111 @@ func (s *EmailService) SendWelcome(toEmail, userName string) error {
 	}
 	return nil
 }
+
+// SendVerification sends the email address confirmation link.
+func (s *EmailService) SendVerification(toEmail, userName, link string) error {
+	greeting := "Hi,"
+	if userName != "" {
+		greeting = fmt.Sprintf("Hi %s,", html.EscapeString(userName))
+	}
+	body := fmt.Sprintf(`<p>%s</p><p>Please confirm your email address by clicking the link below:</p><p><a href="%s">Verify email address</a></p><p>If you did not create an account, you can ignore this email.</p>`,
+		greeting, html.EscapeString(link))
+
+	_, err := s.client.Emails.Send(&resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{toEmail},
+		Subject: "Verify your email address",
+		Html:    body,
+	})
+	if err != nil {
+		Logger.Error("Failed to send verification email", "err", err, "to", toEmail)
+		return err
+	}
+	return nil
+}
+
+// SendPasswordReset sends the password reset link.
+func (s *EmailService) SendPasswordReset(toEmail, userName, link string) error {
+	greeting := "Hi,"
+	if userName != "" {
+		greeting = fmt.Sprintf("Hi %s,", html.EscapeString(userName))
+	}
+	body := fmt.Sprintf(`<p>%s</p><p>We received a request to reset your password. Click the link below to choose a new one:</p><p><a href="%s">Reset password</a></p><p>If you did not request this, you can ignore this email.</p>`,
+		greeting, html.EscapeString(link))
+
+	_, err := s.client.Emails.Send(&resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{toEmail},
+		Subject: "Reset your password",
+		Html:    body,
+	})
+	if err != nil {
+		Logger.Error("Failed to send password reset email", "err", err, "to", toEmail)
+		return err
+	}
+	return nil
+}
+
+// SendMagicLink sends a one-time sign-in link.
+func (s *EmailService) SendMagicLink(toEmail, link string) error {
+	body := fmt.Sprintf(`<p>Hi,</p><p>Click the link below to sign in to Scaffold. The link can be used once and expires shortly.</p><p><a href="%s">Sign in</a></p><p>If you did not request this, you can ignore this email.</p>`,
+		html.EscapeString(link))
+
+	_, err := s.client.Emails.Send(&resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{toEmail},
+		Subject: "Your sign-in link",
+		Html:    body,
+	})
+	if err != nil {
+		Logger.Error("Failed to send magic link email", "err", err, "to", toEmail)
+		return err
+	}
+	return nil
+}
+
+// SendAccountLocked tells the owner their account was locked after repeated
+// failed sign-ins and includes a link to unlock it.
+func (s *EmailService) SendAccountLocked(toEmail, userName, link string) error {
+	greeting := "Hi,"
+	if userName != "" {
+		greeting = fmt.Sprintf("Hi %s,", html.EscapeString(userName))
+	}
+	body := fmt.Sprintf(`<p>%s</p><p>Your account was temporarily locked after several failed sign-in attempts. If this was you, click the link below to unlock it now:</p><p><a href="%s">Unlock account</a></p><p>If this was not you, consider resetting your password.</p>`,
+		greeting, html.EscapeString(link))
+
+	_, err := s.client.Emails.Send(&resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{toEmail},
+		Subject: "Your account has been locked",
+		Html:    body,
+	})
+	if err != nil {
+		Logger.Error("Failed to send account locked email", "err", err, "to", toEmail)
+		return err
+	}
+	return nil
+}
+
+// SendOrganizationInvitation invites toEmail to join an organization and
+// includes a link to accept or decline.
+func (s *EmailService) SendOrganizationInvitation(toEmail, orgName, inviterName, link string) error {
+	inviter := "Someone"
+	if inviterName != "" {
+		inviter = html.EscapeString(inviterName)
+	}
+	body := fmt.Sprintf(`<p>Hi,</p><p>%s invited you to join <strong>%s</strong>. Click the link below to accept or decline the invitation:</p><p><a href="%s">View invitation</a></p><p>If you were not expecting this, you can ignore this email.</p>`,
+		inviter, html.EscapeString(orgName), html.EscapeString(link))
+
+	_, err := s.client.Emails.Send(&resend.SendEmailRequest{
+		From:    s.from,
+		To:      []string{toEmail},
+		Subject: fmt.Sprintf("You're invited to join %s", orgName),
+		Html:    body,
+	})
+	if err != nil {
+		Logger.Error("Failed to send organization invitation email", "err", err, "to", toEmail)
+		return err
+	}
+	return nil
+}
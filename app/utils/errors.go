@@ -3,6 +3,7 @@ package utils
 import (
 	"errors"
 	"net/http"
+	"strings"
 )
 
 // AppError represents an application error
@@ -70,3 +71,24 @@ func ErrorToStatusCode(err error) int {
 	return http.StatusInternalServerError
 }
 
+// NewErrorResponse builds the JSON error body for err. The error code is
+// derived from the status, e.g. "not_found". AppError messages are meant for
+// users and are passed through; other server errors get a generic message so
+// internal details are not leaked.
+func NewErrorResponse(err error) ErrorResponse {
+	status := ErrorToStatusCode(err)
+	resp := ErrorResponse{
+		Error: strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+	}
+
+	var appErr *AppError
+	switch {
+	case errors.As(err, &appErr):
+		resp.Message = appErr.Message
+	case status >= http.StatusInternalServerError:
+		resp.Message = "An unexpected error occurred"
+	default:
+		resp.Message = err.Error()
+	}
+	return resp
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/dariubs/scaffold/app/config"
+)
+
+// NewToken returns a random URL-safe token together with its signed hash.
+// Send the token to the user and store only the hash.
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, SignToken(token), nil
+}
+
+// SignToken returns the HMAC-SHA256 of token keyed by SESSION_SECRET, so a
+// leaked token table cannot be replayed without the secret.
+func SignToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(config.C.Session.Secret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/dariubs/scaffold/app/config"
+)
+
+func TestSignToken(t *testing.T) {
+	config.C = &config.Config{}
+	config.C.Session.Secret = "secret"
+
+	// HMAC-SHA256("secret", "token")
+	const want = "e941110e3d2bfe82621f0e3e1434730d7305d106c5f68c87165d0b27a4611a4a"
+	got := SignToken("token")
+	if got != want {
+		t.Fatalf("SignToken() = %q, want %q", got, want)
+	}
+	if SignToken("other") == got {
+		t.Error("different tokens have the same signature")
+	}
+	config.C.Session.Secret = "rotated"
+	if SignToken("token") == got {
+		t.Error("the signature does not depend on the session secret")
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	config.C = &config.Config{}
+	config.C.Session.Secret = "secret"
+
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(token); err != nil || len(b) != 32 {
+		t.Errorf("NewToken() token = %q, want 32 random bytes", token)
+	}
+	if hash != SignToken(token) {
+		t.Error("NewToken() hash is not SignToken(token)")
+	}
+	if other, _, _ := NewToken(); other == token {
+		t.Error("NewToken() returned the same token twice")
+	}
+}
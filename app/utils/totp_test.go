@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test key ("12345678901234567890") in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// RFC 6238 SHA-1 vectors, truncated to six digits
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range tests {
+		if got := totpCode(key, unix/totpPeriod); got != want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / totpPeriod
+	code := totpCode([]byte("12345678901234567890"), step)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code, now, step, true},
+		{"previous step allowed", rfcSecret, code, now.Add(totpPeriod * time.Second), step, true},
+		{"next step allowed", rfcSecret, code, now.Add(-totpPeriod * time.Second), step, true},
+		{"two steps late", rfcSecret, code, now.Add(2 * totpPeriod * time.Second), 0, false},
+		{"two steps early", rfcSecret, code, now.Add(-2 * totpPeriod * time.Second), 0, false},
+		{"surrounding spaces", rfcSecret, " " + code + " ", now, step, true},
+		{"lowercase secret", strings.ToLower(rfcSecret), code, now, step, true},
+		{"wrong code", rfcSecret, "000000", now, 0, false},
+		{"too short", rfcSecret, code[:5], now, 0, false},
+		{"too long", rfcSecret, code + "0", now, 0, false},
+		{"invalid secret", "not base32!", code, now, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, tt.at)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("NewTOTPSecret() = %q, want 20 bytes of base32", secret)
+	}
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if _, ok := ValidateTOTP(secret, code, time.Now()); !ok {
+		t.Error("a fresh secret does not validate its own code")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("Scaffold", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Scaffold:alice@example.com" {
+		t.Errorf("TOTPURI() = %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Scaffold" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("TOTPURI() query = %v", q)
+	}
+}
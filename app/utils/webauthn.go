@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"github.com/dariubs/scaffold/app/config"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// NewWebAuthn creates the WebAuthn relying party used for passkeys. If the
+// relying party is not configured, returns (nil, nil) so callers can skip
+// passkey routes.
+func NewWebAuthn() (*webauthn.WebAuthn, error) {
+	if !config.C.WebAuthnConfigured() {
+		return nil, nil
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          config.C.WebAuthn.RPID,
+		RPDisplayName: config.C.WebAuthn.RPDisplayName,
+		RPOrigins:     config.C.WebAuthn.RPOrigins,
+	})
+}